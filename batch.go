@@ -0,0 +1,62 @@
+package clipremote
+
+import (
+	"context"
+
+	"github.com/chocolatkey/clipremote/pkg/commands"
+	"github.com/chocolatkey/clipremote/pkg/packets"
+	"github.com/pkg/errors"
+)
+
+var ErrSkipped = errors.New("skipped because an earlier command failed")
+
+type BatchItem struct {
+	Command commands.Command `json:"command"`
+	Detail  interface{}      `json:"detail,omitempty"`
+}
+
+type BatchResult struct {
+	Response *packets.ServerCommand
+	Err      error
+}
+
+// Whether the result is an error, including errors responded by the server.
+func (r BatchResult) Failed() bool {
+	return r.Err != nil || (r.Response != nil && r.Response.Type == packets.TypeServerResponseError)
+}
+
+// Send several commands, returning their results in the same order.
+// All commands are sent right away without waiting for responses, unless stopOnError is set,
+// in which case they are sent one after the other and the ones after the first failure are skipped.
+// Commands still waiting for a response when the context is done fail like with SendCommandContext.
+func (c *Client) SendBatch(ctx context.Context, items []BatchItem, stopOnError bool) []BatchResult {
+	results := make([]BatchResult, len(items))
+	if stopOnError {
+		for i, item := range items {
+			scp, err := c.SendCommandContext(ctx, item.Command, item.Detail)
+			results[i] = BatchResult{Response: scp, Err: err}
+			if results[i].Failed() {
+				for j := i + 1; j < len(items); j++ {
+					results[j].Err = ErrSkipped
+				}
+				break
+			}
+		}
+		return results
+	}
+
+	if c.opts.requestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.opts.requestTimeout)
+		defer cancel()
+	}
+	pending := make([]*pendingCommand, len(items))
+	for i, item := range items {
+		pending[i] = c.start(item.Command, item.Detail)
+	}
+	for i, p := range pending {
+		scp, err := p.wait(ctx)
+		results[i] = BatchResult{Response: scp, Err: err}
+	}
+	return results
+}
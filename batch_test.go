@@ -0,0 +1,56 @@
+package clipremote
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/chocolatkey/clipremote/pkg/commands"
+	"github.com/chocolatkey/clipremote/pkg/packets"
+	"github.com/pkg/errors"
+)
+
+func TestSendBatch(t *testing.T) {
+	f := newFakeCSP(t, func(command commands.Command) packets.PacketType {
+		switch command {
+		case commands.GetModifyKeyString:
+			return 0
+		case commands.SetServerSelectedTabKind:
+			return packets.TypeServerResponseError
+		}
+		return packets.TypeServerResponseSuccess
+	})
+	client := connectFake(t, f)
+
+	ok, refused, silent := BatchItem{Command: commands.GetServerSelectedTabKind}, BatchItem{Command: commands.SetServerSelectedTabKind}, BatchItem{Command: commands.GetModifyKeyString}
+	tests := []struct {
+		name        string
+		items       []BatchItem
+		stopOnError bool
+		errs        []error // nil for a response, or the error
+		failed      []bool
+	}{
+		{"all at once", []BatchItem{ok, refused, ok}, false, []error{nil, nil, nil}, []bool{false, true, false}},
+		{"stop on error", []BatchItem{ok, refused, ok}, true, []error{nil, nil, ErrSkipped}, []bool{false, true, true}},
+		{"gives up at once", []BatchItem{ok, silent, ok}, false, []error{nil, ErrTimeout, nil}, []bool{false, true, false}},
+		{"gives up in turn", []BatchItem{ok, silent, ok}, true, []error{nil, ErrTimeout, ErrSkipped}, []bool{false, true, true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			results := client.SendBatch(ctx, tt.items, tt.stopOnError)
+			for i, result := range results {
+				if !errors.Is(result.Err, tt.errs[i]) {
+					t.Errorf("%d: got error %v, want %v", i, result.Err, tt.errs[i])
+				}
+				if result.Failed() != tt.failed[i] {
+					t.Errorf("%d: failed %v", i, result.Failed())
+				}
+			}
+			if n := client.Pending(); n != 0 {
+				t.Errorf("%d callbacks left", n)
+			}
+		})
+	}
+}
@@ -2,10 +2,11 @@ package clipremote
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"encoding/hex"
-	"fmt"
 	"io"
-	"net"
+	"math"
 	"net/url"
 	"strconv"
 	"strings"
@@ -23,56 +24,128 @@ import (
 )
 
 type Client struct {
-	atomicSerial atomic.Uint32
-	conn         net.Conn
-	callbacks    cmap.ConcurrentMap[packets.Serial, packets.ClientCommandCallback]
-	password     string
-	generation   string
-	timeout      *time.Timer
-	alive        bool
+	atomicSerial      atomic.Uint32
+	serialEpoch       atomic.Uint32             // Bumped whenever serials restart, so they may be reused
+	conn              atomic.Pointer[Transport] // Only replaced while writeMu is held, see transport
+	open              TransportFactory          // Opens a new transport when reconnecting
+	writeMu           sync.Mutex                // Held while a command is written
+	callbacks         cmap.ConcurrentMap[packets.Serial, packets.ClientCommandCallback]
+	ipAddresses       []string
+	port              uint16
+	password          string
+	generation        string
+	timeout           *time.Timer
+	heartbeatInterval atomic.Int64 // Idle time after which a heartbeat is sent, a time.Duration
+	alive             atomic.Bool
+	keepaliveRunning  atomic.Bool // Whether the keepalive loop is running
+	subscribers       subscribers
+	wireTap           atomic.Pointer[WireTap]
+	opts              clientOptions
+	invoke            Invoker // Sends commands through the interceptors
+	log               logrus.FieldLogger
+	dialAttempts      []DialAttempt // How connecting went, see DialAttempts
+}
+
+// WireTap sees every packet as it goes over the wire, including the type byte and terminator.
+type WireTap func(outgoing bool, data []byte)
+
+// Call tap with every packet sent and received, for debugging. Nil removes the tap.
+func (c *Client) SetWireTap(tap WireTap) {
+	if tap == nil {
+		c.wireTap.Store(nil)
+		return
+	}
+	c.wireTap.Store(&tap)
 }
 
 func (c *Client) Close() error {
 	c.Reset()
-	return c.conn.Close()
+	c.emit(EventDisconnected, "", nil)
+	return c.transport().Close()
+}
+
+// Current transport. Commands are written to the one that's current when writeMu is taken.
+func (c *Client) transport() Transport {
+	return *c.conn.Load()
+}
+
+// Replace the transport, once commands being written to the old one are done, and close the old one.
+func (c *Client) setTransport(t Transport) {
+	c.writeMu.Lock()
+	old := c.conn.Swap(&t)
+	c.writeMu.Unlock()
+	(*old).Close()
 }
 
 func (c *Client) Reset() {
-	if c.alive {
-		c.alive = false
-	}
-	logrus.Infoln("client-side reset")
+	c.alive.Store(false)
+	c.log.Infoln("client-side reset")
+	c.emit(EventReset, "client-side reset", nil)
 	c.callbacks.IterCb(func(key packets.Serial, v packets.ClientCommandCallback) {
-		logrus.Debugln("removing callback for client-side reset", key)
-		v(nil, errors.New("client-side reset"))
+		c.log.Debugln("removing callback for client-side reset", key)
+		v(nil, ErrClientReset)
 	})
 	c.callbacks.Clear()
-	c.atomicSerial.Store(0)
+	c.restartSerials(0)
+}
+
+// Continue serials from the given one.
+func (c *Client) restartSerials(serial uint32) {
+	c.serialEpoch.Add(1)
+	c.atomicSerial.Store(serial)
+}
+
+// Drop the callback of a command given up on, unless serials restarted since it was sent
+// and another command may have its serial now.
+func (c *Client) forget(serial packets.Serial, epoch uint32) {
+	c.callbacks.RemoveCb(serial, func(_ packets.Serial, _ packets.ClientCommandCallback, exists bool) bool {
+		return exists && c.serialEpoch.Load() == epoch
+	})
 }
 
 func (c *Client) reconnect() error {
 	c.Reset()
-	nconn, err := net.Dial("tcp", c.conn.RemoteAddr().String())
+	ctx, cancel := context.WithTimeout(context.Background(), c.opts.dialTimeout)
+	nconn, err := c.open(ctx)
+	cancel()
 	if err != nil {
 		return errors.Wrap(err, "failed reconnecting")
 	}
-	c.conn = nconn
+	c.setTransport(nconn)
 	c.Reset()
+	c.emit(EventReconnected, c.RemoteAddr(), nil)
 	go c.loop()
 	c.Reauthenticate(func(scp *packets.ServerCommand, err error) {
-		if err != nil {
+		if err != nil && !errors.Is(err, ErrClientReset) { // Reset if it reconnected again already
 			c.Close()
 		}
 	})
 	return nil
 }
 
+// Change how long the connection may be idle before a heartbeat is sent.
+func (c *Client) SetHeartbeatInterval(interval time.Duration) {
+	c.heartbeatInterval.Store(int64(interval))
+	c.timeout.Reset(interval)
+}
+
+// Idle time after which a heartbeat is sent.
+func (c *Client) idleTimeout() time.Duration {
+	return time.Duration(c.heartbeatInterval.Load())
+}
+
 func (c *Client) Alive() bool {
-	return c.alive
+	return c.alive.Load()
+}
+
+// Number of commands still waiting for a response.
+func (c *Client) Pending() int {
+	return c.callbacks.Count()
 }
 
+// Where the transport goes, empty if it doesn't say.
 func (c *Client) RemoteAddr() string {
-	return c.conn.RemoteAddr().String()
+	return transportAddr(c.transport())
 }
 
 func (c *Client) callbackForSerial(serial packets.Serial, scp *packets.ServerCommand, err error) {
@@ -82,24 +155,27 @@ func (c *Client) callbackForSerial(serial packets.Serial, scp *packets.ServerCom
 		if scp.Type == packets.TypeClientCommand {
 			if scp.Serial == 0 {
 				// Reset
-				logrus.Infof("server-side reset: %v+", scp)
+				c.log.Infof("server-side reset: %v+", scp)
+				c.emit(EventReset, "server-side reset", scp)
 				c.callbacks.IterCb(func(key packets.Serial, v packets.ClientCommandCallback) {
-					logrus.Debugln("removing callback for server-side reset", key)
-					v(nil, errors.New("server-side reset"))
+					c.log.Debugln("removing callback for server-side reset", key)
+					v(nil, ErrServerReset)
 				})
 				c.callbacks.Clear()
 			}
-			c.atomicSerial.Store(uint32(scp.Serial) + 1)
-			c.timeout.Reset(protocol.HeartbeatTimeout)
+			c.restartSerials(uint32(scp.Serial) + 1)
+			c.timeout.Reset(c.idleTimeout())
+			c.emit(EventPacket, "", scp)
 		} else {
-			logrus.Warnf("received response for unknown serial %d: %v+", serial, scp)
+			c.log.Warnf("received response for unknown serial %d: %v+", serial, scp)
 		}
 	}
 }
 
 func (c *Client) loop() error {
+	reader := bufio.NewReader(c.transport()) // Reused, it may have read the start of the next packet already
 	for {
-		data, err := bufio.NewReader(c.conn).ReadBytes(protocol.CommandTerminator)
+		data, err := reader.ReadBytes(protocol.CommandTerminator)
 		if err == io.EOF {
 			if err := c.reconnect(); err != nil {
 				return errors.Wrap(err, "connection was closed, and reconnection failed")
@@ -110,6 +186,9 @@ func (c *Client) loop() error {
 			c.Close()
 			return errors.Wrap(err, "failed reading command response")
 		}
+		if tap := c.wireTap.Load(); tap != nil {
+			(*tap)(false, data)
+		}
 		var pkt packets.ServerCommand
 		err = pkt.Parse(data)
 		if err != nil {
@@ -121,29 +200,57 @@ func (c *Client) loop() error {
 	}
 }
 
+// Serial invokers return for commands that weren't written.
+const NotSent = packets.Serial(math.MaxUint32)
+
+// Send a command through the interceptors, calling callback with the response.
 func (c *Client) SendCommand(command commands.Command, detail interface{}, callback packets.ClientCommandCallback) {
-	if !c.alive && command != commands.Authenticate {
-		callback(nil, errors.New("client is not alive"))
-		return
-	}
-	cmd := packets.ClientCommand{
+	c.invoke(&packets.ClientCommand{
 		Command:  command,
-		Serial:   packets.Serial(c.atomicSerial.Load()),
 		Detail:   detail,
 		Callback: callback,
+	})
+}
+
+// Write the command, at the end of the interceptor chain. Returns the serial it was written with.
+func (c *Client) send(cmd *packets.ClientCommand) packets.Serial {
+	if !c.alive.Load() && cmd.Command != commands.Authenticate {
+		cmd.Callback(nil, ErrNotAlive)
+		return NotSent
 	}
-	c.atomicSerial.Add(1)
 
-	err := cmd.Write(c.conn)
+	// Serials have to go out in order, and the callback must be in place before
+	// the response can possibly arrive, so many commands can be in flight at once
+	c.writeMu.Lock()
+	serial := packets.Serial(c.atomicSerial.Add(1) - 1)
+	cmd.Serial = serial
+	c.callbacks.Set(serial, cmd.Callback)
+	conn := c.transport()
+	var err error
+	if tap := c.wireTap.Load(); tap != nil {
+		var buf bytes.Buffer
+		err = cmd.Write(io.MultiWriter(conn, &buf))
+		(*tap)(true, buf.Bytes())
+	} else {
+		err = cmd.Write(conn)
+	}
+	c.writeMu.Unlock()
 	if err != nil {
-		cmd.Callback(nil, errors.Wrap(err, "failed writing command"))
+		if callback, ok := c.callbacks.Pop(serial); ok {
+			callback(nil, errors.Wrap(err, "failed writing command"))
+		}
+		return NotSent
 	}
 
-	c.timeout.Reset(protocol.HeartbeatTimeout)
-	c.callbacks.Set(cmd.Serial, cmd.Callback)
+	c.timeout.Reset(c.idleTimeout())
+	return serial
 }
 
+// Send a command and wait for its response, or until the request timeout if there is one.
 func (c *Client) SendCommandSync(command commands.Command, detail interface{}) (scp *packets.ServerCommand, err error) {
+	if c.opts.requestTimeout > 0 {
+		return c.SendCommandContext(context.Background(), command, detail)
+	}
 	var wg sync.WaitGroup
 	wg.Add(1)
 	c.SendCommand(
@@ -159,6 +266,68 @@ func (c *Client) SendCommandSync(command commands.Command, detail interface{}) (
 	return
 }
 
+// Like SendCommandSync, but gives up waiting when the context is done.
+// ErrTimeout is returned if that's because of its deadline, or the request timeout.
+func (c *Client) SendCommandContext(ctx context.Context, command commands.Command, detail interface{}) (*packets.ServerCommand, error) {
+	if c.opts.requestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.opts.requestTimeout)
+		defer cancel()
+	}
+	return c.start(command, detail).wait(ctx)
+}
+
+// Command sent without waiting for its response yet.
+type pendingCommand struct {
+	c      *Client
+	serial packets.Serial
+	epoch  uint32
+	done   chan response
+}
+
+type response struct {
+	scp *packets.ServerCommand
+	err error
+}
+
+// Send a command through the interceptors, to wait for its response later.
+func (c *Client) start(command commands.Command, detail interface{}) *pendingCommand {
+	p := &pendingCommand{
+		c:     c,
+		epoch: c.serialEpoch.Load(),
+		done:  make(chan response, 1), // The response may still come after giving up
+	}
+	p.serial = c.invoke(&packets.ClientCommand{
+		Command: command,
+		Detail:  detail,
+		Callback: func(scp *packets.ServerCommand, err error) {
+			p.done <- response{scp, err}
+		},
+	})
+	return p
+}
+
+// Wait for the response, dropping the callback if the context is done first.
+func (p *pendingCommand) wait(ctx context.Context) (*packets.ServerCommand, error) {
+	select {
+	case r := <-p.done:
+		return r.scp, r.err
+	case <-ctx.Done():
+		select {
+		case r := <-p.done: // Came in meanwhile, as in a batch waited for in turn
+			return r.scp, r.err
+		default:
+		}
+		if p.serial != NotSent {
+			p.c.forget(p.serial, p.epoch)
+		}
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, ErrTimeout
+		}
+		return nil, ctx.Err()
+	}
+}
+
 func (c *Client) Authenticate(callback packets.ClientCommandCallback, password string) {
 	currPass := []byte(password)
 	crypto.ObfuscateAuthParam(currPass)
@@ -178,15 +347,29 @@ func (c *Client) Authenticate(callback packets.ClientCommandCallback, password s
 			return
 		}
 		if scp.Type == packets.TypeServerResponseError {
-			callback(scp, errors.New("authentication failed"))
-			c.atomicSerial.Store(0)
+			callback(scp, serverError(scp, ErrAuthFailed))
+			c.restartSerials(0)
 			return
 		}
 		c.password = newPassword
-		go c.keepalive()
+		c.startKeepalive()
+		c.emit(EventAuthenticated, "", scp)
+		callback(scp, nil)
 	})
 }
 
+func (c *Client) AuthenticateSync(password string) (scp *packets.ServerCommand, err error) {
+	var wg sync.WaitGroup
+	wg.Add(1)
+	c.Authenticate(func(s *packets.ServerCommand, e error) {
+		defer wg.Done()
+		scp = s
+		err = e
+	}, password)
+	wg.Wait()
+	return
+}
+
 func (c *Client) Reauthenticate(callback packets.ClientCommandCallback) {
 	currPass := make([]byte, len(c.password))
 	copy(currPass, c.password)
@@ -206,51 +389,61 @@ func (c *Client) Reauthenticate(callback packets.ClientCommandCallback) {
 			return
 		}
 		if scp.Type == packets.TypeServerResponseError {
-			callback(scp, errors.New("reauthentication failed"))
-			c.atomicSerial.Store(0)
+			callback(scp, serverError(scp, ErrAuthFailed))
+			c.restartSerials(0)
 			return
 		}
-		go c.keepalive()
+		c.startKeepalive()
+		c.emit(EventAuthenticated, "reauthenticated", scp)
+		callback(scp, nil)
 	})
 }
 
 func (c *Client) Heartbeat(callback packets.ClientCommandCallback, idleTimerResetRequested bool) {
-	c.SendCommand(commands.TellHeartbeat, map[string]bool{
-		"IdleTimerResetRequested": true,
+	c.SendCommand(commands.TellHeartbeat, commands.DetailTellHeartbeatRequest{
+		IdleTimerResetRequested: true,
 	}, func(scp *packets.ServerCommand, err error) {
 		if err != nil || scp.Type == packets.TypeServerResponseError {
 			if err == nil {
-				err = errors.New("heartbeat failed")
+				err = scp.Err()
 			}
 			callback(scp, err)
 			return
 		}
-		c.timeout.Reset(protocol.HeartbeatTimeout)
+		c.timeout.Reset(c.idleTimeout())
 		callback(scp, nil)
 	})
 }
 
-func (c *Client) keepalive() {
-	if c.alive {
+// Mark the client alive and start sending heartbeats, unless that's already happening.
+func (c *Client) startKeepalive() {
+	if !c.alive.CompareAndSwap(false, true) {
 		return
 	}
-	c.alive = true
+	if c.keepaliveRunning.CompareAndSwap(false, true) {
+		go c.keepalive()
+	}
+}
+
+func (c *Client) keepalive() {
+	defer c.keepaliveRunning.Store(false)
 	for {
 		select {
 		case <-c.timeout.C:
-			if !c.alive {
+			if !c.alive.Load() {
 				return
 			}
 			c.Heartbeat(func(scp *packets.ServerCommand, err error) {
 				if err != nil {
-					logrus.Debugln("heartbeat error", err.Error())
-					c.alive = false
+					c.log.Debugln("heartbeat error", err.Error())
+					c.emit(EventHeartbeatFailed, err.Error(), scp)
+					c.alive.Store(false)
 					c.timeout.Stop()
 					c.Reauthenticate(func(scp *packets.ServerCommand, err error) {
 						if err != nil {
 							c.Close()
 						} else {
-							c.timeout.Reset(protocol.HeartbeatTimeout)
+							c.timeout.Reset(c.idleTimeout())
 						}
 					})
 				}
@@ -301,31 +494,18 @@ func DecodeConfig(connectionURL string) (ipAddresses []string, port uint16, pass
 	return
 }
 
-// Connect to the CSP server.
-func Connect(ipAddresses []string, port uint16, generation string) (*Client, error) {
-	var client *Client
-	for i, address := range ipAddresses {
-		host := fmt.Sprintf("%s:%d", address, port)
-		logrus.Debugln("dialing", host)
-		conn, err := net.Dial("tcp", host)
-		if err != nil {
-			if i == len(ipAddresses)-1 {
-				return nil, errors.Wrap(err, "failed dialing "+address)
-			}
-			continue
-		}
-
-		client = &Client{
-			conn:       conn,
-			generation: generation,
-			timeout:    time.NewTimer(protocol.HeartbeatTimeout),
-			callbacks:  cmap.NewStringer[packets.Serial, packets.ClientCommandCallback](),
-		}
-		client.Reset()
-		break
+// Connect to the CSP server. The addresses are dialed in turn, each one getting a head start
+// (see WithDialStagger) before the next is dialed too. With WithPassword the first to
+// authenticate is kept, otherwise the first to connect, which may not be CSP at all.
+func New(ctx context.Context, ipAddresses []string, port uint16, generation string, opts ...ClientOption) (*Client, error) {
+	o := defaultClientOptions()
+	for _, opt := range opts {
+		opt(&o)
 	}
+	return dial(ctx, ipAddresses, port, generation, o)
+}
 
-	logrus.Infoln("connected to " + client.RemoteAddr())
-	go client.loop()
-	return client, nil
+// Connect to the CSP server with the default options. See New for more control.
+func Connect(ipAddresses []string, port uint16, generation string) (*Client, error) {
+	return New(context.Background(), ipAddresses, port, generation)
 }
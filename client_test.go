@@ -0,0 +1,140 @@
+package clipremote
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chocolatkey/clipremote/pkg/commands"
+	"github.com/chocolatkey/clipremote/pkg/packets"
+	"github.com/pkg/errors"
+)
+
+// Connect to the fake and authenticate.
+func connectFake(t *testing.T, f *fakeCSP, opts ...ClientOption) *Client {
+	t.Helper()
+	client, err := New(context.Background(), []string{"127.0.0.1"}, f.port(), "gen", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	if _, err := client.AuthenticateSync("password"); err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestSendCommandContext(t *testing.T) {
+	f := newFakeCSP(t, func(command commands.Command) packets.PacketType {
+		switch command {
+		case commands.GetModifyKeyString:
+			return 0
+		case commands.SetServerSelectedTabKind:
+			return packets.TypeServerResponseError
+		}
+		return packets.TypeServerResponseSuccess
+	})
+	refuse := errors.New("refused")
+	client := connectFake(t, f, WithInterceptors(func(cmd *packets.ClientCommand, next Invoker) packets.Serial {
+		if cmd.Command == commands.PreviewWebtoonFromClient {
+			cmd.Callback(nil, refuse)
+			return NotSent
+		}
+		return next(cmd)
+	}))
+
+	tests := []struct {
+		command commands.Command
+		typ     packets.PacketType
+		err     error
+	}{
+		{commands.GetServerSelectedTabKind, packets.TypeServerResponseSuccess, nil},
+		{commands.SetServerSelectedTabKind, packets.TypeServerResponseError, nil},
+		{commands.GetModifyKeyString, 0, ErrTimeout},
+		{commands.PreviewWebtoonFromClient, 0, refuse},
+	}
+	for _, tt := range tests {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		scp, err := client.SendCommandContext(ctx, tt.command, nil)
+		cancel()
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: got error %v, want %v", tt.command, err, tt.err)
+		}
+		if tt.typ != 0 && (scp == nil || scp.Type != tt.typ) {
+			t.Errorf("%s: got response %v, want type %x", tt.command, scp, tt.typ)
+		}
+		if n := client.Pending(); n != 0 {
+			t.Errorf("%s: %d callbacks left", tt.command, n)
+		}
+	}
+}
+
+func TestReconnectWhileSending(t *testing.T) {
+	f := newFakeCSP(t, nil)
+	client := connectFake(t, f)
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				client.SendCommandContext(context.Background(), commands.GetServerSelectedTabKind, nil)
+			}
+		}()
+	}
+	for i := 0; i < 3; i++ {
+		time.Sleep(20 * time.Millisecond)
+		f.drop()
+	}
+	close(stop)
+	wg.Wait()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !client.Alive() {
+		if time.Now().After(deadline) {
+			t.Fatal("not alive again after reconnecting")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := client.SendCommandSync(commands.GetServerSelectedTabKind, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReconnectGivesUp(t *testing.T) {
+	server, conn := net.Pipe()
+	gaveUp := make(chan error, 1)
+	calls := 0
+	client, err := NewWithTransport(context.Background(), func(ctx context.Context) (Transport, error) {
+		calls++
+		if calls == 1 {
+			return conn, nil
+		}
+		<-ctx.Done() // CSP never answers
+		gaveUp <- ctx.Err()
+		return nil, ctx.Err()
+	}, "gen", WithDialTimeout(50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	server.Close()
+	select {
+	case err := <-gaveUp:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("got %v, want the dial timeout", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("still reconnecting")
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+
+	"github.com/chocolatkey/clipremote"
+	"github.com/chocolatkey/clipremote/pkg/commands"
+	"github.com/chocolatkey/clipremote/pkg/packets"
+	"github.com/chocolatkey/clipremote/pkg/preview"
+	"github.com/chocolatkey/clipremote/pkg/webtoon"
+	"github.com/pkg/errors"
+)
+
+// Same as cmd/server, see the TODO there.
+const galleryMaxLength = preview.BlockHeight
+
+func runDecode(opts *options, args []string) error {
+	args = opts.parse(args)
+	if len(args) != 1 {
+		opts.flags.Usage()
+		os.Exit(2)
+	}
+	session, err := clipremote.SessionFromURL(args[0])
+	if err != nil {
+		return err
+	}
+	opts.print(session, func(w io.Writer) {
+		fmt.Fprintln(w, "Addresses: ", strings.Join(session.IPAddresses, ", "))
+		fmt.Fprintln(w, "Port:      ", session.Port)
+		fmt.Fprintln(w, "Password:  ", session.Password)
+		fmt.Fprintln(w, "Generation:", session.Generation)
+	})
+	return nil
+}
+
+// Round-trip a response's generically decoded detail into a typed struct.
+func decodeDetail(scp *packets.ServerCommand, v interface{}) error {
+	bin, err := json.Marshal(scp.Detail)
+	if err != nil {
+		return errors.Wrap(err, "failed re-encoding detail")
+	}
+	return errors.Wrap(json.Unmarshal(bin, v), "failed decoding detail")
+}
+
+func printResponse(w io.Writer, scp *packets.ServerCommand) {
+	kind := "success"
+	switch scp.Type {
+	case packets.TypeServerResponseError:
+		kind = "error"
+	case packets.TypeClientCommand:
+		kind = "command" // Sent by CSP on its own
+	}
+	fmt.Fprintf(w, "%s %s (serial %d)\n", kind, scp.Command, scp.Serial)
+	if scp.Detail != nil {
+		bin, _ := json.MarshalIndent(scp.Detail, "", "  ")
+		fmt.Fprintln(w, string(bin))
+	}
+	if scp.Data != nil {
+		fmt.Fprintf(w, "data: %d bytes\n", len(scp.Data))
+	}
+}
+
+// Command and detail to send for a command name and JSON detail. Known commands and operations,
+// like "PreviewWebtoonFromClient/UpdateGallery", have their detail checked.
+func parseCommand(name string, detail string) (commands.Command, interface{}, error) {
+	if spec, ok := commands.Lookup(name); ok && !spec.Internal {
+		decoded, err := spec.DecodeDetail([]byte(detail))
+		return spec.Command, decoded, err
+	}
+	var decoded interface{}
+	if strings.TrimSpace(detail) != "" {
+		if err := json.Unmarshal([]byte(detail), &decoded); err != nil {
+			return "", nil, errors.Wrap(err, "detail is not valid JSON")
+		}
+	}
+	return commands.Command(name), decoded, nil
+}
+
+func runSend(opts *options, args []string) error {
+	args = opts.parse(args)
+	if len(args) < 1 || len(args) > 2 {
+		opts.flags.Usage()
+		os.Exit(2)
+	}
+	detail := ""
+	if len(args) == 2 {
+		detail = args[1]
+	}
+	command, decoded, err := parseCommand(args[0], detail)
+	if err != nil {
+		return err
+	}
+
+	client, err := opts.connect()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	scp, err := client.SendCommandSync(command, decoded)
+	if err != nil {
+		return err
+	}
+	opts.print(scp, func(w io.Writer) { printResponse(w, scp) })
+	if scp.Type == packets.TypeServerResponseError {
+		return errors.New("CSP refused the command")
+	}
+	return nil
+}
+
+func runKeys(opts *options, args []string) error {
+	var req commands.DetailGetModifyKeyStringRequest
+	opts.flags.BoolVar(&req.AltPushed, "alt", false, "Alt is pressed")
+	opts.flags.BoolVar(&req.CtrlPushed, "ctrl", false, "Ctrl is pressed")
+	opts.flags.BoolVar(&req.ShiftPushed, "shift", false, "Shift is pressed")
+	opts.parse(args)
+
+	client, err := opts.connect()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	scp, err := client.SendCommandSync(commands.GetModifyKeyString, req)
+	if err != nil {
+		return err
+	}
+	if scp.Type == packets.TypeServerResponseError {
+		return errors.New("CSP refused the command")
+	}
+	var keys commands.DetailGetModifyKeyStringResponse
+	if err = decodeDetail(scp, &keys); err != nil {
+		return err
+	}
+	opts.print(keys, func(w io.Writer) {
+		fmt.Fprintln(w, "Alt:   ", keys.AltDescription)
+		fmt.Fprintln(w, "Ctrl:  ", keys.CtrlDescription)
+		fmt.Fprintln(w, "Shift: ", keys.ShiftDescription)
+		fmt.Fprintln(w, "System:", keys.SystemKind)
+	})
+	return nil
+}
+
+func runGallery(opts *options, args []string) error {
+	opts.parse(args)
+	client, err := opts.connect()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	gallery, err := preview.UpdateGallery(client, galleryMaxLength)
+	if err != nil {
+		return err
+	}
+	opts.print(gallery, func(w io.Writer) {
+		fmt.Fprintf(w, "Gallery %d, %d canvases\n", gallery.GalleryIdentificationNumber, len(gallery.CanvasSizeArray))
+		for i, size := range gallery.CanvasSizeArray {
+			fmt.Fprintf(w, "%4d  %5d×%-6d %3d blocks\n", i, size.CanvasWidth, size.CanvasHeight, len(preview.Blocks(size)))
+		}
+	})
+	return nil
+}
+
+func runExport(opts *options, args []string) error {
+	var names []string
+	for name := range webtoon.Presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	presetName := opts.flags.String("preset", "webtoon", "Size to slice the canvases for ("+strings.Join(names, ", ")+")")
+	dir := opts.flags.String("dir", ".", "Directory to write the strips to")
+	concurrency := opts.flags.Int("concurrency", preview.DefaultFetchOptions.Concurrency, "Blocks requested at once")
+	opts.parse(args)
+	preset, ok := webtoon.Presets[*presetName]
+	if !ok {
+		return errors.New("unknown preset " + *presetName)
+	}
+	if err := os.MkdirAll(*dir, 0o755); err != nil {
+		return errors.Wrap(err, "failed creating directory")
+	}
+
+	client, err := opts.connect()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	fetch := preview.DefaultFetchOptions
+	fetch.Concurrency = *concurrency
+	if !opts.json {
+		fetch.Progress = func(done, total int) {
+			fmt.Fprintf(os.Stderr, "\r%d/%d blocks", done, total)
+			if done == total {
+				fmt.Fprintln(os.Stderr)
+			}
+		}
+	}
+	paths, err := webtoon.ExportGallery(client, galleryMaxLength, preset, *dir, fetch)
+	opts.print(paths, func(w io.Writer) {
+		for _, path := range paths {
+			fmt.Fprintln(w, path)
+		}
+	})
+	return err
+}
+
+func runWatch(opts *options, args []string) error {
+	opts.parse(args)
+	client, err := opts.connect()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	events, unsubscribe := client.Subscribe(256)
+	defer unsubscribe()
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+
+	for {
+		select {
+		case event := <-events:
+			if opts.json {
+				json.NewEncoder(os.Stdout).Encode(event) // One per line
+			} else {
+				fmt.Printf("%s %s %s\n", event.Time.Format("15:04:05.000"), event.Type, event.Message)
+				if event.Packet != nil {
+					printResponse(os.Stdout, event.Packet)
+				}
+			}
+			if event.Type == clipremote.EventDisconnected {
+				return errors.New("disconnected")
+			}
+		case <-interrupt:
+			return nil
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/chocolatkey/clipremote/pkg/commands"
+	"github.com/chocolatkey/clipremote/pkg/transcript"
+	"github.com/pkg/errors"
+)
+
+type inferredDetail struct {
+	Name    string          `json:"name"`
+	Kind    transcript.Kind `json:"kind"`
+	Known   bool            `json:"known"`
+	Samples int             `json:"samples"`
+	Errors  int             `json:"errors,omitempty"`
+	Schema  commands.Schema `json:"schema"`
+}
+
+func runInfer(opts *options, args []string) error {
+	unknownOnly := opts.flags.Bool("unknown", false, "Only commands and operations that aren't in pkg/commands yet")
+	args = opts.parse(args)
+	if len(args) == 0 {
+		opts.flags.Usage()
+		os.Exit(2)
+	}
+
+	inferrer := transcript.NewInferrer()
+	for _, path := range args {
+		f, err := os.Open(path)
+		if err != nil {
+			return errors.Wrap(err, "failed opening transcript")
+		}
+		entries, err := transcript.Read(f)
+		f.Close()
+		if err != nil {
+			return errors.Wrap(err, path)
+		}
+		for _, entry := range entries {
+			if err = inferrer.Add(entry); err != nil {
+				fmt.Fprintln(os.Stderr, "skipping packet:", err)
+			}
+		}
+	}
+
+	var details []*transcript.Detail
+	for _, d := range inferrer.Details() {
+		if _, known := commands.Lookup(d.Name()); !known || !*unknownOnly {
+			details = append(details, d)
+		}
+	}
+	if opts.json {
+		inferred := make([]inferredDetail, 0, len(details))
+		for _, d := range details {
+			_, known := commands.Lookup(d.Name())
+			inferred = append(inferred, inferredDetail{
+				Name:    d.Name(),
+				Kind:    d.Kind,
+				Known:   known,
+				Samples: d.Shape.Count,
+				Errors:  d.Errors,
+				Schema:  d.Shape.Schema(),
+			})
+		}
+		opts.print(inferred, nil)
+		return nil
+	}
+	fmt.Fprintf(os.Stderr, "%d packets, %d details\n", inferrer.Packets, len(details))
+	return transcript.GenerateGo(os.Stdout, details)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/chocolatkey/clipremote"
+	"github.com/chocolatkey/clipremote/pkg/transcript"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+type subcommand struct {
+	name  string
+	args  string
+	usage string
+	run   func(opts *options, args []string) error
+}
+
+var subcommands = []subcommand{
+	{"decode", "<Share URL>", "Print what's in a share URL", runDecode},
+	{"send", "<command> [detail]", "Send a command, with the detail as JSON", runSend},
+	{"keys", "", "Show what the modifier keys do", runKeys},
+	{"gallery", "", "List the canvases in the webtoon preview gallery", runGallery},
+	{"export", "", "Export the gallery's canvases as strips", runExport},
+	{"watch", "", "Print connection events and packets sent by CSP until interrupted", runWatch},
+	{"repl", "", "Explore commands interactively", runREPL},
+	{"infer", "<transcript>...", "Infer the details of commands from recorded traffic", runInfer},
+	{"probe", "<wordlist>", "Try the commands in a wordlist to find ones CSP knows", runProbe},
+}
+
+// Options every subcommand has.
+type options struct {
+	flags       *flag.FlagSet
+	shareURL    string
+	sessionFile string
+	json        bool
+	verbose     bool
+	record      string
+	recorder    *transcript.Recorder // Set once connected, if recording
+	session     *clipremote.Session  // Of the last connection, for connecting again
+}
+
+func defaultSessionFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "clipremote", "session.json")
+}
+
+func newOptions(cmd subcommand) *options {
+	opts := &options{flags: flag.NewFlagSet(cmd.name, flag.ExitOnError)}
+	opts.flags.StringVar(&opts.shareURL, "url", os.Getenv("CLIPREMOTE_SHARE_URL"), "Share URL from the QR code. Only needed the first time, or when CSP shows a new one")
+	opts.flags.StringVar(&opts.sessionFile, "session", defaultSessionFile(), "File the session is kept in between runs")
+	opts.flags.BoolVar(&opts.json, "json", false, "Print JSON instead of text")
+	opts.flags.BoolVar(&opts.verbose, "v", false, "Log what's sent and received")
+	opts.flags.StringVar(&opts.record, "record", "", "File to append the packets sent and received to, for infer")
+	opts.flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: clipremote %s [options] %s\n\n%s\n\n", cmd.name, cmd.args, cmd.usage)
+		opts.flags.PrintDefaults()
+	}
+	return opts
+}
+
+// Parse the flags, returning the remaining arguments.
+func (o *options) parse(args []string) []string {
+	o.flags.Parse(args)
+	logrus.SetLevel(logrus.WarnLevel) // Connection details are only noise here
+	if o.verbose {
+		logrus.SetLevel(logrus.DebugLevel)
+	}
+	return o.flags.Args()
+}
+
+// Print v as JSON, or with the text function.
+func (o *options) print(v interface{}, text func(w io.Writer)) {
+	if o.json {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(v)
+		return
+	}
+	text(os.Stdout)
+}
+
+// Connect and authenticate, with the session of the last connection when connecting again,
+// the share URL if there is one, or else the saved session. The session is saved after,
+// since the password changes every time.
+func (o *options) connect() (*clipremote.Client, error) {
+	session := o.session
+	var err error
+	switch {
+	case session != nil:
+	case o.shareURL != "":
+		session, err = clipremote.SessionFromURL(o.shareURL)
+	case o.sessionFile != "":
+		session, err = clipremote.LoadSession(o.sessionFile)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, errors.New("no saved session, pass the share URL with -url")
+		}
+	default:
+		return nil, errors.New("pass the share URL with -url")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := clipremote.ConnectSession(context.Background(), session)
+	if err != nil {
+		return nil, err
+	}
+	for _, attempt := range client.DialAttempts() {
+		logrus.Debugf("%s: connect %v, authenticate %v, won %v, error %v", attempt.Address, attempt.Latency, attempt.AuthLatency, attempt.Won, attempt.Err)
+	}
+	if o.record != "" && o.recorder == nil {
+		f, err := os.OpenFile(o.record, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+		if err != nil {
+			client.Close()
+			return nil, errors.Wrap(err, "failed opening transcript")
+		}
+		o.recorder = transcript.NewRecorder(f) // Left open until exiting
+	}
+	if o.recorder != nil {
+		client.SetWireTap(o.recorder.Tap)
+	}
+	o.session = client.Session()
+	if o.sessionFile != "" {
+		if err := os.MkdirAll(filepath.Dir(o.sessionFile), 0o700); err != nil {
+			logrus.Warnln("failed saving session:", err)
+		} else if err := o.session.Save(o.sessionFile); err != nil {
+			logrus.Warnln("failed saving session:", err)
+		}
+	}
+	return client, nil
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: clipremote <command> [options] [arguments]")
+	fmt.Fprintln(os.Stderr)
+	for _, cmd := range subcommands {
+		fmt.Fprintf(os.Stderr, "  %-8s %s\n", cmd.name, cmd.usage)
+	}
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Run clipremote <command> -h for the options of a command.")
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	for _, cmd := range subcommands {
+		if cmd.name != os.Args[1] {
+			continue
+		}
+		opts := newOptions(cmd)
+		if err := cmd.run(opts, os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			os.Exit(1)
+		}
+		return
+	}
+	usage()
+	os.Exit(2)
+}
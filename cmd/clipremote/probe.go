@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"time"
+
+	"github.com/chocolatkey/clipremote/pkg/discovery"
+	"github.com/pkg/errors"
+)
+
+func printResult(w io.Writer, result discovery.Result) {
+	detail, _ := json.Marshal(result.Detail)
+	fmt.Fprintf(w, "%-10s %s %s", result.Class, result.Name(), detail)
+	if result.Known {
+		fmt.Fprint(w, " (known)")
+	}
+	fmt.Fprintln(w)
+}
+
+func runProbe(opts *options, args []string) error {
+	shapesFile := opts.flags.String("shapes", "", "JSON array of details to try names without their own with (default null and {})")
+	rate := opts.flags.Float64("rate", 5, "Commands sent per second")
+	timeout := opts.flags.Duration("timeout", discovery.DefaultOptions.Timeout, "How long to wait for a response")
+	reportFile := opts.flags.String("report", "", "File to write the full report to as JSON")
+	args = opts.parse(args)
+	if len(args) != 1 || *rate <= 0 {
+		opts.flags.Usage()
+		os.Exit(2)
+	}
+
+	shapes := discovery.DefaultShapes
+	if *shapesFile != "" {
+		bin, err := os.ReadFile(*shapesFile)
+		if err != nil {
+			return errors.Wrap(err, "failed reading shapes")
+		}
+		if err = json.Unmarshal(bin, &shapes); err != nil {
+			return errors.Wrap(err, "shapes must be a JSON array")
+		}
+	}
+	wordlist := os.Stdin
+	if args[0] != "-" {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return errors.Wrap(err, "failed opening wordlist")
+		}
+		defer f.Close()
+		wordlist = f
+	}
+	candidates, err := discovery.ParseWordlist(wordlist, shapes)
+	if err != nil {
+		return err
+	}
+
+	probeOpts := discovery.DefaultOptions
+	probeOpts.Interval = time.Duration(float64(time.Second) / *rate)
+	probeOpts.Timeout = *timeout
+	if !opts.json {
+		probeOpts.Progress = func(result discovery.Result) { printResult(os.Stderr, result) }
+	}
+	prober := discovery.NewProber(func() (discovery.Client, error) {
+		client, err := opts.connect()
+		if err != nil {
+			return nil, err
+		}
+		return client, nil
+	}, probeOpts)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	report, err := prober.Run(ctx, candidates)
+	if errors.Is(err, context.Canceled) {
+		err = nil // Interrupted, the report so far is still useful
+	}
+
+	if *reportFile != "" {
+		bin, _ := json.MarshalIndent(report, "", "  ")
+		if werr := os.WriteFile(*reportFile, bin, 0o644); werr != nil {
+			return errors.Wrap(werr, "failed writing report")
+		}
+	}
+	opts.print(report, func(w io.Writer) {
+		counts := report.Count()
+		var classes []string
+		for class := range counts {
+			classes = append(classes, string(class))
+		}
+		sort.Strings(classes)
+		fmt.Fprintf(w, "%d tried in %s, %d reconnects\n", len(report.Results), report.Finished.Sub(report.Started).Round(time.Second), report.Reconnects)
+		for _, class := range classes {
+			fmt.Fprintf(w, "  %-10s %d\n", class, counts[discovery.Class(class)])
+		}
+		fmt.Fprintln(w, "Found:")
+		for _, result := range report.Results {
+			if !result.Known && (result.Class == discovery.ClassSuccess || result.Class == discovery.ClassError) {
+				printResult(w, result)
+			}
+		}
+	})
+	return err
+}
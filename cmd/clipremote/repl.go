@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/chocolatkey/clipremote"
+	"github.com/chocolatkey/clipremote/pkg/commands"
+	"github.com/chocolatkey/clipremote/pkg/transcript"
+	"github.com/chzyer/readline"
+	"github.com/pkg/errors"
+)
+
+const replHelp = `Type a command and its detail as JSON, like:
+  GetModifyKeyString {"AltPushed":false,"CtrlPushed":true,"ShiftPushed":false}
+Known commands complete with Tab, and Tab after a command fills in its detail.
+
+  .edit <command>  Write the detail in $EDITOR, then send it
+  .raw             Show/hide the packets as they go over the wire
+  .events          Show/hide events and packets sent by CSP on its own
+  .help            Show this
+  .quit            Exit (or Ctrl-D)`
+
+var replCommands = []string{".edit", ".raw", ".events", ".help", ".quit"}
+
+// Maximum packet length shown in raw mode, preview blocks can be megabytes.
+const maxRawLength = 1024
+
+type repl struct {
+	client   *clipremote.Client
+	rl       *readline.Instance
+	recorder *transcript.Recorder // Nil unless recording
+	raw      atomic.Bool          // Read by the client's goroutine
+	events   atomic.Bool          // Read by the goroutine printing events
+
+	detailsMu sync.Mutex        // The completer runs on readline's goroutine
+	details   map[string]string // Last detail sent for each command, to start editing from
+}
+
+// Detail to start from for the command, the last one sent or else a template.
+func (r *repl) startingDetail(name string) string {
+	r.detailsMu.Lock()
+	detail, ok := r.details[name]
+	r.detailsMu.Unlock()
+	if !ok {
+		detail = detailTemplate(name)
+	}
+	return detail
+}
+
+func (r *repl) rememberDetail(name, detail string) {
+	r.detailsMu.Lock()
+	defer r.detailsMu.Unlock()
+	r.details[name] = detail
+}
+
+// Names that can be typed as the command, see commands.Spec.Name.
+func replNames() []string {
+	var names []string
+	for _, spec := range commands.Registry {
+		if !spec.Internal {
+			names = append(names, spec.Name())
+		}
+	}
+	sort.Strings(names)
+	return append(names, replCommands...)
+}
+
+// Detail to start from for a known command, with every field at its zero value.
+func detailTemplate(name string) string {
+	spec, ok := commands.Lookup(name)
+	if !ok || spec.Request == nil {
+		return ""
+	}
+	bin, _ := json.Marshal(reflect.New(spec.Request).Interface())
+	if spec.Operation != "" {
+		var fields map[string]interface{}
+		if json.Unmarshal(bin, &fields) == nil {
+			delete(fields, "Operation") // Filled in from the name
+			bin, _ = json.Marshal(fields)
+		}
+	}
+	return string(bin)
+}
+
+// Completes the command name, then its detail.
+type replCompleter struct {
+	r *repl
+}
+
+func (c replCompleter) Do(line []rune, pos int) ([][]rune, int) {
+	typed := string(line[:pos])
+	name, rest, hasSpace := strings.Cut(typed, " ")
+	if !hasSpace {
+		var candidates [][]rune
+		for _, n := range replNames() {
+			if strings.HasPrefix(n, name) {
+				candidates = append(candidates, []rune(n[len(name):]+" "))
+			}
+		}
+		return candidates, len([]rune(name))
+	}
+	if strings.TrimSpace(rest) != "" {
+		return nil, 0
+	}
+	detail := c.r.startingDetail(name)
+	if detail == "" {
+		return nil, 0
+	}
+	return [][]rune{[]rune(detail)}, 0
+}
+
+func (r *repl) printRaw(outgoing bool, data []byte) {
+	arrow := "<-"
+	if outgoing {
+		arrow = "->"
+	}
+	shown := data
+	if len(shown) > maxRawLength {
+		shown = shown[:maxRawLength]
+	}
+	fmt.Fprintf(r.rl.Stdout(), "%s %q", arrow, shown)
+	if len(shown) < len(data) {
+		fmt.Fprintf(r.rl.Stdout(), "... (%d bytes)", len(data))
+	}
+	fmt.Fprintln(r.rl.Stdout())
+}
+
+func (r *repl) tap(outgoing bool, data []byte) {
+	if r.recorder != nil {
+		r.recorder.Tap(outgoing, data)
+	}
+	if r.raw.Load() {
+		r.printRaw(outgoing, data)
+	}
+}
+
+func (r *repl) toggleRaw() {
+	raw := !r.raw.Load()
+	r.raw.Store(raw)
+	if raw {
+		fmt.Fprintln(r.rl.Stdout(), "Showing raw packets")
+	} else {
+		fmt.Fprintln(r.rl.Stdout(), "Hiding raw packets")
+	}
+}
+
+func (r *repl) watch(events <-chan clipremote.Event) {
+	for event := range events {
+		if !r.events.Load() || event.Type == clipremote.EventAuthenticated {
+			continue
+		}
+		fmt.Fprintf(r.rl.Stdout(), "* %s %s\n", event.Type, event.Message)
+		if event.Packet != nil {
+			printResponse(r.rl.Stdout(), event.Packet)
+		}
+	}
+}
+
+// Let the user write the detail in their editor, starting from the last one sent.
+func (r *repl) edit(name string) (string, error) {
+	detail := r.startingDetail(name)
+	var pretty bytes.Buffer
+	if json.Indent(&pretty, []byte(detail), "", "  ") == nil {
+		detail = pretty.String()
+	}
+
+	f, err := os.CreateTemp("", "clipremote-*.json")
+	if err != nil {
+		return "", errors.Wrap(err, "failed creating file to edit")
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(detail + "\n")
+	f.Close()
+
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+	fields := strings.Fields(editor)
+	cmd := exec.Command(fields[0], append(fields[1:], f.Name())...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err = cmd.Run(); err != nil {
+		return "", errors.Wrap(err, "editor failed")
+	}
+	bin, err := os.ReadFile(f.Name())
+	if err != nil {
+		return "", errors.Wrap(err, "failed reading edited detail")
+	}
+	return strings.TrimSpace(string(bin)), nil
+}
+
+func (r *repl) send(name string, detail string) error {
+	command, decoded, err := parseCommand(name, detail)
+	if err != nil {
+		return err
+	}
+	started := time.Now()
+	scp, err := r.client.SendCommandSync(command, decoded)
+	if err != nil {
+		return err
+	}
+	if detail != "" {
+		var compact bytes.Buffer
+		if json.Compact(&compact, []byte(detail)) == nil {
+			r.rememberDetail(name, compact.String())
+		}
+	}
+	printResponse(r.rl.Stdout(), scp)
+	fmt.Fprintf(r.rl.Stdout(), "took %s\n", time.Since(started).Round(time.Microsecond))
+	return nil
+}
+
+// Run one line of input. Returns false to exit.
+func (r *repl) run(line string) bool {
+	name, detail, _ := strings.Cut(strings.TrimSpace(line), " ")
+	detail = strings.TrimSpace(detail)
+	var err error
+	switch name {
+	case "":
+	case ".quit", ".exit":
+		return false
+	case ".help":
+		fmt.Fprintln(r.rl.Stdout(), replHelp)
+	case ".raw":
+		r.toggleRaw()
+	case ".events":
+		r.events.Store(!r.events.Load())
+		fmt.Fprintln(r.rl.Stdout(), "Showing events:", r.events.Load())
+	case ".edit":
+		if detail == "" {
+			err = errors.New("usage: .edit <command>")
+			break
+		}
+		name = detail
+		if detail, err = r.edit(name); err == nil {
+			err = r.send(name, detail)
+		}
+	default:
+		if strings.HasPrefix(name, ".") {
+			err = errors.New("unknown command " + name + ", see .help")
+			break
+		}
+		err = r.send(name, detail)
+	}
+	if err != nil {
+		fmt.Fprintln(r.rl.Stderr(), "error:", err)
+	}
+	return true
+}
+
+func defaultHistoryFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "clipremote", "history")
+}
+
+func runREPL(opts *options, args []string) error {
+	historyFile := opts.flags.String("history", defaultHistoryFile(), "File the command history is kept in")
+	raw := opts.flags.Bool("raw", false, "Start with raw packets shown")
+	opts.parse(args)
+
+	client, err := opts.connect()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	r := &repl{client: client, recorder: opts.recorder, details: make(map[string]string)}
+	r.events.Store(true)
+	if *historyFile != "" {
+		os.MkdirAll(filepath.Dir(*historyFile), 0o700)
+	}
+	r.rl, err = readline.NewEx(&readline.Config{
+		Prompt:            "csp> ",
+		HistoryFile:       *historyFile,
+		AutoComplete:      replCompleter{r},
+		HistorySearchFold: true,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed starting line editor")
+	}
+	defer r.rl.Close()
+
+	events, unsubscribe := client.Subscribe(256)
+	defer unsubscribe()
+	go r.watch(events)
+	r.raw.Store(*raw)
+	client.SetWireTap(r.tap)
+
+	fmt.Fprintln(r.rl.Stdout(), "Connected to", client.RemoteAddr()+". Type .help for help.")
+	for {
+		line, err := r.rl.Readline()
+		if err == readline.ErrInterrupt {
+			continue // Ctrl-C clears the line
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !r.run(line) {
+			return nil
+		}
+	}
+}
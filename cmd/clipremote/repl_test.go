@@ -0,0 +1,30 @@
+package main
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestReplDetailsWhileCompleting(t *testing.T) {
+	r := &repl{details: make(map[string]string)}
+	completer := replCompleter{r}
+	line := []rune("GetServerSelectedTabKind ")
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			completer.Do(line, len(line))
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		r.rememberDetail("GetServerSelectedTabKind", `{"a":1}`)
+	}
+	wg.Wait()
+
+	got, _ := completer.Do(line, len(line))
+	if len(got) != 1 || string(got[0]) != `{"a":1}` {
+		t.Errorf("got %q, want the last detail sent", got)
+	}
+}
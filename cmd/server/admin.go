@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/chocolatkey/clipremote"
+)
+
+type pairRequest struct {
+	ShareURL string `json:"share_url"`
+}
+
+type pairResponse struct {
+	RemoteAddress string `json:"remote_address"`
+	Generation    string `json:"generation"`
+}
+
+// Pairs the server with CSP again, after it showed a new QR code. The body is either
+// {"share_url": "..."} or an image of the QR code, like a screenshot.
+func pairHandler(conn *connection) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("allow", http.MethodPost)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !tokenFrom(r.Context()).IsAdmin() {
+			http.Error(w, "Token is not allowed to pair", http.StatusForbidden)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, 16<<20))
+		if err != nil {
+			http.Error(w, "Bad request body", http.StatusBadRequest)
+			return
+		}
+		var shareURL string
+		if strings.HasPrefix(r.Header.Get("content-type"), "image/") {
+			img, _, err := image.Decode(bytes.NewReader(body))
+			if err != nil {
+				http.Error(w, "Invalid image, must be PNG or JPEG", http.StatusBadRequest)
+				return
+			}
+			if shareURL, err = clipremote.DecodeQR(img); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		} else {
+			var req pairRequest
+			if err := json.Unmarshal(body, &req); err != nil || req.ShareURL == "" {
+				http.Error(w, "Body must be {\"share_url\": ...} or an image", http.StatusBadRequest)
+				return
+			}
+			shareURL = req.ShareURL
+		}
+
+		session, err := clipremote.SessionFromURL(shareURL)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err = conn.Pair(session); err != nil {
+			writeError(w, err, http.StatusBadGateway)
+			return
+		}
+
+		w.Header().Set("content-type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(pairResponse{
+			RemoteAddress: conn.Client().RemoteAddr(),
+			Generation:    session.Generation,
+		})
+	}
+}
+
+// The "pair" subcommand, which asks a running server to pair again.
+func pairCommand(args []string) int {
+	fs := flag.NewFlagSet("pair", flag.ExitOnError)
+	server := fs.String("server", "http://127.0.0.1:8089", "URL of the running server")
+	token := fs.String("token", os.Getenv("CLIPREMOTE_TOKEN"), "API token, if the server requires one")
+	instance := fs.String("instance", "", "Instance to pair, the default one if empty")
+	fs.Usage = func() {
+		println("Usage: server pair [-server <URL>] [-token <token>] [-instance <name>] <Share URL or QR code image file>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	var body []byte
+	contentType := "application/json"
+	if img, err := os.ReadFile(fs.Arg(0)); err == nil {
+		body = img
+		contentType = http.DetectContentType(img)
+	} else {
+		body, _ = json.Marshal(pairRequest{ShareURL: fs.Arg(0)})
+	}
+
+	endpoint := strings.TrimSuffix(*server, "/")
+	if *instance != "" {
+		endpoint += "/instances/" + url.PathEscape(*instance)
+	}
+	req, err := http.NewRequest(http.MethodPost, endpoint+"/admin/pair", bytes.NewReader(body))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	req.Header.Set("content-type", contentType)
+	if *token != "" {
+		req.Header.Set("authorization", "Bearer "+*token)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer res.Body.Close()
+	resBody, _ := io.ReadAll(res.Body)
+	if res.StatusCode != http.StatusOK {
+		fmt.Fprintf(os.Stderr, "%s: %s", res.Status, resBody)
+		return 1
+	}
+	os.Stdout.Write(resBody)
+	return 0
+}
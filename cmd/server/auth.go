@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/chocolatkey/clipremote"
+	"github.com/chocolatkey/clipremote/pkg/commands"
+	"github.com/chocolatkey/clipremote/pkg/packets"
+	"github.com/pkg/errors"
+)
+
+var errForbidden = errors.New("command is not allowed for this token")
+
+// API token and the commands it may send. Rules are command names like "GetModifyKeyString",
+// or "PreviewWebtoonFromClient/ReadPreviewBlock" for a single operation, or "*" for everything.
+// Deny rules win over allow rules. An empty allow list allows everything not denied.
+// Admin tokens can also use the /admin endpoints.
+type apiToken struct {
+	Name  string   `json:"name" yaml:"name" toml:"name"`
+	Token string   `json:"token" yaml:"token" toml:"token"`
+	Allow []string `json:"allow,omitempty" yaml:"allow,omitempty" toml:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty" yaml:"deny,omitempty" toml:"deny,omitempty"`
+	Admin bool     `json:"admin,omitempty" yaml:"admin,omitempty" toml:"admin,omitempty"`
+}
+
+type authConfig struct {
+	Tokens []apiToken `json:"tokens"`
+}
+
+// Tokens can be defined in the config, or in a separate JSON file.
+func loadAuthConfig(path string) (*authConfig, error) {
+	bin, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed reading tokens file")
+	}
+	var auth authConfig
+	if err = json.Unmarshal(bin, &auth); err != nil {
+		return nil, errors.Wrap(err, "failed parsing tokens file")
+	}
+	return &auth, nil
+}
+
+func ruleMatches(rule string, command commands.Command, operation string) bool {
+	if rule == "*" || rule == string(command) {
+		return true
+	}
+	return operation != "" && rule == string(command)+"/"+operation
+}
+
+// Whether the token may send the command with the given detail.
+func (t *apiToken) Allows(command commands.Command, detail interface{}) bool {
+	if t == nil {
+		return true // Authentication is disabled
+	}
+	operation := commands.OperationOf(detail)
+	for _, rule := range t.Deny {
+		if ruleMatches(rule, command, operation) {
+			return false
+		}
+	}
+	if len(t.Allow) == 0 {
+		return true
+	}
+	for _, rule := range t.Allow {
+		if ruleMatches(rule, command, operation) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *apiToken) IsAdmin() bool {
+	return t == nil || t.Admin // Everyone is when authentication is disabled
+}
+
+type tokenContextKey struct{}
+
+// Token the request was authenticated with, nil if authentication is disabled.
+func tokenFrom(ctx context.Context) *apiToken {
+	token, _ := ctx.Value(tokenContextKey{}).(*apiToken)
+	return token
+}
+
+// Require a valid bearer token on every request. Browsers can't set headers for WebSocket and
+// EventSource connections, so the token is also accepted as the access_token query parameter.
+func (a *authConfig) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		provided := r.URL.Query().Get("access_token")
+		if header := r.Header.Get("Authorization"); header != "" {
+			scheme, credentials, _ := strings.Cut(header, " ")
+			if !strings.EqualFold(scheme, "Bearer") {
+				w.Header().Set("www-authenticate", "Bearer")
+				http.Error(w, "Only Bearer authorization is supported", http.StatusUnauthorized)
+				return
+			}
+			provided = strings.TrimSpace(credentials)
+		}
+		for i := range a.Tokens {
+			token := &a.Tokens[i]
+			if subtle.ConstantTimeCompare([]byte(provided), []byte(token.Token)) == 1 {
+				next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tokenContextKey{}, token)))
+				return
+			}
+		}
+		w.Header().Set("www-authenticate", "Bearer")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	})
+}
+
+// Client limited to the commands the request's token allows. Waiting for a response stops
+// when the request is done.
+type guardedClient struct {
+	*clipremote.Client
+	token *apiToken
+	ctx   context.Context
+}
+
+func guard(conn *connection, ctx context.Context) *guardedClient {
+	return &guardedClient{Client: conn.Client(), token: tokenFrom(ctx), ctx: ctx}
+}
+
+// Operations whose responses are kept in the gallery state or block cache.
+var (
+	updateGalleryDetail    = commands.DetailPreviewWebtoonFromClientRequestUpdateGallery{Operation: "UpdateGallery"}
+	readPreviewBlockDetail = commands.DetailPreviewWebtoonFromClientReadPreviewBlock{Operation: "ReadPreviewBlock"}
+)
+
+// Whether the token can send the command, for responses served without sending it again.
+func (g *guardedClient) allow(command commands.Command, detail interface{}) error {
+	if !g.token.Allows(command, detail) {
+		return errForbidden
+	}
+	return nil
+}
+
+func (g *guardedClient) SendCommand(command commands.Command, detail interface{}, callback packets.ClientCommandCallback) {
+	if !g.token.Allows(command, detail) {
+		callback(nil, errForbidden)
+		return
+	}
+	g.Client.SendCommand(command, detail, callback)
+}
+
+func (g *guardedClient) SendCommandSync(command commands.Command, detail interface{}) (*packets.ServerCommand, error) {
+	if !g.token.Allows(command, detail) {
+		return nil, errForbidden
+	}
+	return g.Client.SendCommandContext(g.ctx, command, detail)
+}
+
+func (g *guardedClient) SendBatch(items []clipremote.BatchItem, stopOnError bool) []clipremote.BatchResult {
+	for _, item := range items {
+		if !g.token.Allows(item.Command, item.Detail) {
+			results := make([]clipremote.BatchResult, len(items))
+			for i, item := range items {
+				if g.token.Allows(item.Command, item.Detail) {
+					results[i].Err = clipremote.ErrSkipped
+				} else {
+					results[i].Err = errForbidden
+				}
+			}
+			return results
+		}
+	}
+	return g.Client.SendBatch(g.ctx, items, stopOnError)
+}
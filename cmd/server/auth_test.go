@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/chocolatkey/clipremote/pkg/commands"
+)
+
+func TestTokenAllows(t *testing.T) {
+	read := map[string]interface{}{"Operation": "ReadPreviewBlock"}
+	update := map[string]interface{}{"Operation": "UpdateGallery"}
+	tests := []struct {
+		name    string
+		token   *apiToken
+		command commands.Command
+		detail  interface{}
+		want    bool
+	}{
+		{"authentication disabled", nil, commands.GetServerSelectedTabKind, nil, true},
+		{"no rules", &apiToken{}, commands.GetServerSelectedTabKind, nil, true},
+		{"allowed command", &apiToken{Allow: []string{"PreviewWebtoonFromClient"}}, commands.PreviewWebtoonFromClient, update, true},
+		{"other command", &apiToken{Allow: []string{"PreviewWebtoonFromClient"}}, commands.GetServerSelectedTabKind, nil, false},
+		{"allowed operation", &apiToken{Allow: []string{"PreviewWebtoonFromClient/ReadPreviewBlock"}}, commands.PreviewWebtoonFromClient, read, true},
+		{"other operation", &apiToken{Allow: []string{"PreviewWebtoonFromClient/ReadPreviewBlock"}}, commands.PreviewWebtoonFromClient, update, false},
+		{"denied operation", &apiToken{Deny: []string{"PreviewWebtoonFromClient/UpdateGallery"}}, commands.PreviewWebtoonFromClient, update, false},
+		{"deny wins", &apiToken{Allow: []string{"*"}, Deny: []string{"PreviewWebtoonFromClient"}}, commands.PreviewWebtoonFromClient, read, false},
+		{"wildcard", &apiToken{Allow: []string{"*"}}, commands.GetServerSelectedTabKind, nil, true},
+	}
+	for _, tt := range tests {
+		if got := tt.token.Allows(tt.command, tt.detail); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	auth := &authConfig{Tokens: []apiToken{{Name: "viewer", Token: "0123456789abcdef"}}}
+	handler := auth.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(tokenFrom(r.Context()).Name))
+	}))
+	tests := []struct {
+		name          string
+		authorization string
+		query         string
+		want          int
+	}{
+		{"bearer", "Bearer 0123456789abcdef", "", http.StatusOK},
+		{"lowercase scheme", "bearer 0123456789abcdef", "", http.StatusOK},
+		{"query", "", "?access_token=0123456789abcdef", http.StatusOK},
+		{"wrong token", "Bearer fedcba9876543210", "", http.StatusUnauthorized},
+		{"no token", "", "", http.StatusUnauthorized},
+		{"basic", "Basic 0123456789abcdef", "", http.StatusUnauthorized},
+		{"bare token", "0123456789abcdef", "", http.StatusUnauthorized},
+		{"header wins over query", "Bearer wrong", "?access_token=0123456789abcdef", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/events"+tt.query, nil)
+		if tt.authorization != "" {
+			r.Header.Set("authorization", tt.authorization)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("%s: got status %d, want %d", tt.name, w.Code, tt.want)
+		}
+		if w.Code == http.StatusOK && w.Body.String() != "viewer" {
+			t.Errorf("%s: authenticated as %q", tt.name, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/chocolatkey/clipremote"
+	"github.com/chocolatkey/clipremote/pkg/commands"
+	"github.com/chocolatkey/clipremote/pkg/packets"
+)
+
+type batchRequestItem struct {
+	Command string          `json:"command"`
+	Detail  json.RawMessage `json:"detail,omitempty"`
+}
+
+type batchResponseItem struct {
+	Response *packets.ServerCommand `json:"response,omitempty"`
+	Error    string                 `json:"error,omitempty"`
+}
+
+// Runs an array of {command, detail} objects, pipelined unless stop_on_error is set.
+// Responds with an array of {response, error} objects in the same order.
+func batchHandler(conn *connection, activity *eventLog) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		stopOnError := false
+		switch r.URL.Query().Get("stop_on_error") {
+		case "", "0", "false":
+		default:
+			stopOnError = true
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+		if err != nil {
+			http.Error(w, "Bad request body", http.StatusBadRequest)
+			return
+		}
+		var reqItems []batchRequestItem
+		if err := json.Unmarshal(body, &reqItems); err != nil {
+			http.Error(w, "Body must be an array of {command, detail} objects", http.StatusBadRequest)
+			return
+		}
+
+		items := make([]clipremote.BatchItem, len(reqItems))
+		for i, reqItem := range reqItems {
+			if reqItem.Command == "" {
+				http.Error(w, "Missing command in item "+strconv.Itoa(i), http.StatusBadRequest)
+				return
+			}
+			items[i].Command = commands.Command(reqItem.Command)
+			if len(reqItem.Detail) > 2 {
+				if err := json.Unmarshal(reqItem.Detail, &items[i].Detail); err != nil {
+					http.Error(w, "Invalid detail in item "+strconv.Itoa(i), http.StatusBadRequest)
+					return
+				}
+			}
+		}
+
+		if !conn.Client().Alive() {
+			http.Error(w, "Not ready", http.StatusServiceUnavailable)
+			return
+		}
+
+		started := time.Now()
+		results := guard(conn, r.Context()).SendBatch(items, stopOnError)
+		respItems := make([]batchResponseItem, len(results))
+		for i, result := range results {
+			if result.Err != clipremote.ErrSkipped {
+				activity.AddCommand("batch", conn.name, items[i].Command, items[i].Detail, started, result.Response, result.Err)
+			}
+			respItems[i].Response = result.Response
+			if result.Err != nil {
+				respItems[i].Error = result.Err.Error()
+			}
+		}
+		w.Header().Set("content-type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(respItems)
+	}
+}
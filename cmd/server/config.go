@@ -0,0 +1,418 @@
+package main
+
+import (
+	"flag"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/chocolatkey/clipremote/pkg/iiif"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// Duration that reads and writes as a string like "3s" in config files.
+type duration time.Duration
+
+func (d duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = duration(parsed)
+	return nil
+}
+
+func (d duration) MarshalYAML() (interface{}, error) {
+	return time.Duration(d).String(), nil
+}
+
+func (d *duration) UnmarshalYAML(value *yaml.Node) error {
+	return d.UnmarshalText([]byte(value.Value))
+}
+
+// Endpoints that can be enabled, all of them by default.
+var allEndpoints = []string{"request", "preview", "panels", "canvas", "iiif", "ws", "events", "commands", "batch", "openapi", "rpc", "admin", "instances"}
+
+// A CSP instance to connect to. Its routes are under /instances/{name}/.
+type instanceConfig struct {
+	Name        string `yaml:"name" toml:"name"`
+	ShareURL    string `yaml:"share_url" toml:"share_url"`
+	SessionFile string `yaml:"session_file" toml:"session_file"`
+}
+
+type config struct {
+	Listen string `yaml:"listen" toml:"listen"`
+	TLS    struct {
+		Cert string `yaml:"cert" toml:"cert"`
+		Key  string `yaml:"key" toml:"key"`
+	} `yaml:"tls" toml:"tls"`
+	Log struct {
+		Level  string `yaml:"level" toml:"level"`
+		Format string `yaml:"format" toml:"format"` // "text" or "json"
+	} `yaml:"log" toml:"log"`
+	ShareURL          string   `yaml:"share_url" toml:"share_url"`
+	SessionFile       string   `yaml:"session_file" toml:"session_file"` // Where the session is kept between restarts
+	HeartbeatInterval duration `yaml:"heartbeat_interval" toml:"heartbeat_interval"`
+	Timeouts          struct {
+		ReadHeader duration `yaml:"read_header" toml:"read_header"`
+		Idle       duration `yaml:"idle" toml:"idle"`
+		Request    duration `yaml:"request" toml:"request"` // How long to wait for CSP to respond, 0 for as long as it takes
+	} `yaml:"timeouts" toml:"timeouts"`
+	IIIF struct {
+		MaxArea       int `yaml:"max_area" toml:"max_area"`               // Largest image served in pixels, since each one is rendered in memory
+		MaxRegionArea int `yaml:"max_region_area" toml:"max_region_area"` // Largest region of a canvas read for an image, in pixels
+	} `yaml:"iiif" toml:"iiif"`
+	Endpoints       []string         `yaml:"endpoints" toml:"endpoints"`
+	AllowedOrigins  []string         `yaml:"allowed_origins" toml:"allowed_origins"` // Web pages allowed to open WebSockets, besides the server's own
+	TokensFile      string           `yaml:"tokens_file" toml:"tokens_file"`
+	Tokens          []apiToken       `yaml:"tokens" toml:"tokens"`
+	Unauthenticated bool             `yaml:"unauthenticated" toml:"unauthenticated"` // Serve without tokens on addresses other machines can reach
+	Mode            string           `yaml:"mode" toml:"mode"`                       // "http", "stdio" (JSON-RPC) or "mcp"
+	Instances       []instanceConfig `yaml:"instances" toml:"instances"`
+}
+
+// Name of the instance configured with the top-level share URL or session file.
+// It also serves the routes that aren't under /instances/.
+const defaultInstance = "default"
+
+// All instances to connect to at startup.
+func (cfg *config) instances() []instanceConfig {
+	var instances []instanceConfig
+	if cfg.ShareURL != "" || cfg.SessionFile != "" {
+		instances = append(instances, instanceConfig{
+			Name:        defaultInstance,
+			ShareURL:    cfg.ShareURL,
+			SessionFile: cfg.SessionFile,
+		})
+	}
+	return append(instances, cfg.Instances...)
+}
+
+func defaultConfig() *config {
+	cfg := &config{
+		Listen:            "127.0.0.1:8089",
+		HeartbeatInterval: duration(3 * time.Second),
+		Endpoints:         allEndpoints,
+		Mode:              "http",
+	}
+	cfg.Log.Level = "info"
+	cfg.Log.Format = "text"
+	cfg.Timeouts.ReadHeader = duration(10 * time.Second)
+	cfg.Timeouts.Idle = duration(2 * time.Minute)
+	cfg.IIIF.MaxArea = iiif.DefaultLimits.MaxArea
+	cfg.IIIF.MaxRegionArea = iiif.DefaultLimits.MaxRegionArea
+	return cfg
+}
+
+// Read a YAML or TOML config file on top of cfg, depending on its extension.
+func (cfg *config) loadFile(path string) error {
+	bin, err := os.ReadFile(path)
+	if err != nil {
+		return errors.Wrap(err, "failed reading config file")
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".toml":
+		err = toml.Unmarshal(bin, cfg)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(bin, cfg)
+	default:
+		return errors.New("config file must be .yaml, .yml or .toml")
+	}
+	return errors.Wrap(err, "failed parsing config file")
+}
+
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Read CLIPREMOTE_* environment variables on top of cfg.
+func (cfg *config) loadEnv() error {
+	strs := map[string]*string{
+		"CLIPREMOTE_LISTEN":       &cfg.Listen,
+		"CLIPREMOTE_TLS_CERT":     &cfg.TLS.Cert,
+		"CLIPREMOTE_TLS_KEY":      &cfg.TLS.Key,
+		"CLIPREMOTE_LOG_LEVEL":    &cfg.Log.Level,
+		"CLIPREMOTE_LOG_FORMAT":   &cfg.Log.Format,
+		"CLIPREMOTE_SHARE_URL":    &cfg.ShareURL,
+		"CLIPREMOTE_SESSION_FILE": &cfg.SessionFile,
+		"CLIPREMOTE_TOKENS_FILE":  &cfg.TokensFile,
+		"CLIPREMOTE_MODE":         &cfg.Mode,
+	}
+	for name, field := range strs {
+		if value, ok := os.LookupEnv(name); ok {
+			*field = value
+		}
+	}
+	durations := map[string]*duration{
+		"CLIPREMOTE_HEARTBEAT_INTERVAL":   &cfg.HeartbeatInterval,
+		"CLIPREMOTE_TIMEOUTS_READ_HEADER": &cfg.Timeouts.ReadHeader,
+		"CLIPREMOTE_TIMEOUTS_IDLE":        &cfg.Timeouts.Idle,
+		"CLIPREMOTE_TIMEOUTS_REQUEST":     &cfg.Timeouts.Request,
+	}
+	for name, field := range durations {
+		if value, ok := os.LookupEnv(name); ok {
+			if err := field.UnmarshalText([]byte(value)); err != nil {
+				return errors.Wrap(err, "invalid "+name)
+			}
+		}
+	}
+	if value, ok := os.LookupEnv("CLIPREMOTE_IIIF_MAX_AREA"); ok {
+		maxArea, err := strconv.Atoi(value)
+		if err != nil {
+			return errors.Wrap(err, "invalid CLIPREMOTE_IIIF_MAX_AREA")
+		}
+		cfg.IIIF.MaxArea = maxArea
+	}
+	if value, ok := os.LookupEnv("CLIPREMOTE_IIIF_MAX_REGION_AREA"); ok {
+		maxRegionArea, err := strconv.Atoi(value)
+		if err != nil {
+			return errors.Wrap(err, "invalid CLIPREMOTE_IIIF_MAX_REGION_AREA")
+		}
+		cfg.IIIF.MaxRegionArea = maxRegionArea
+	}
+	if value, ok := os.LookupEnv("CLIPREMOTE_ENDPOINTS"); ok {
+		cfg.Endpoints = splitList(value)
+	}
+	if value, ok := os.LookupEnv("CLIPREMOTE_UNAUTHENTICATED"); ok {
+		unauthenticated, err := strconv.ParseBool(value)
+		if err != nil {
+			return errors.Wrap(err, "invalid CLIPREMOTE_UNAUTHENTICATED")
+		}
+		cfg.Unauthenticated = unauthenticated
+	}
+	if value, ok := os.LookupEnv("CLIPREMOTE_ALLOWED_ORIGINS"); ok {
+		cfg.AllowedOrigins = splitList(value)
+	}
+	return nil
+}
+
+func (cfg *config) validate() error {
+	var problems []string
+	if _, _, err := net.SplitHostPort(cfg.Listen); err != nil {
+		problems = append(problems, "listen: "+err.Error())
+	}
+	if (cfg.TLS.Cert == "") != (cfg.TLS.Key == "") {
+		problems = append(problems, "tls: both cert and key are needed")
+	}
+	if _, err := logrus.ParseLevel(cfg.Log.Level); err != nil {
+		problems = append(problems, "log.level: "+err.Error())
+	}
+	if cfg.Log.Format != "text" && cfg.Log.Format != "json" {
+		problems = append(problems, "log.format: must be text or json")
+	}
+	instances := cfg.instances()
+	if len(instances) == 0 && cfg.Mode != "http" {
+		problems = append(problems, "share_url or session_file is needed")
+	}
+	names := make(map[string]bool)
+	for _, instance := range instances {
+		prefix := "instances: " + instance.Name + ": "
+		if instance.Name == defaultInstance {
+			prefix = ""
+		}
+		if !instanceNamePattern.MatchString(instance.Name) {
+			problems = append(problems, prefix+"name can only have letters, digits, - and _")
+		} else if names[instance.Name] {
+			problems = append(problems, prefix+"name is used more than once")
+		}
+		names[instance.Name] = true
+		if instance.ShareURL == "" && instance.SessionFile == "" {
+			problems = append(problems, prefix+"share_url or session_file is needed")
+		} else if instance.ShareURL == "" {
+			if _, err := os.Stat(instance.SessionFile); err != nil {
+				problems = append(problems, prefix+"session_file: no saved session, share_url is needed the first time")
+			}
+		}
+	}
+	if cfg.HeartbeatInterval <= 0 {
+		problems = append(problems, "heartbeat_interval: must be positive")
+	}
+	if cfg.Timeouts.Request < 0 {
+		problems = append(problems, "timeouts.request: can't be negative")
+	}
+	if cfg.IIIF.MaxArea <= 0 {
+		problems = append(problems, "iiif.max_area: must be positive")
+	}
+	if cfg.IIIF.MaxRegionArea <= 0 {
+		problems = append(problems, "iiif.max_region_area: must be positive")
+	}
+	for _, endpoint := range cfg.Endpoints {
+		known := false
+		for _, e := range allEndpoints {
+			known = known || e == endpoint
+		}
+		if !known {
+			problems = append(problems, "endpoints: unknown endpoint "+endpoint+", must be one of "+strings.Join(allEndpoints, ", "))
+		}
+	}
+	if len(cfg.Tokens) == 0 && cfg.Mode == "http" && !isLoopback(cfg.Listen) && !cfg.Unauthenticated {
+		problems = append(problems, "tokens: needed to listen on "+cfg.Listen+", or anyone who can reach it can control CSP. Set unauthenticated to allow it anyway")
+	}
+	for _, token := range cfg.Tokens {
+		if len(token.Token) < 16 {
+			problems = append(problems, "tokens: token "+token.Name+" is too short, use at least 16 characters")
+		}
+	}
+	switch cfg.Mode {
+	case "http", "stdio", "mcp":
+	default:
+		problems = append(problems, "mode: must be http, stdio or mcp")
+	}
+
+	if len(problems) > 0 {
+		return errors.New("invalid configuration:\n  " + strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// Whether only this machine can connect to the address.
+func isLoopback(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func (cfg *config) endpointEnabled(name string) bool {
+	for _, endpoint := range cfg.Endpoints {
+		if endpoint == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Copy safe to print, without secrets.
+func (cfg config) redacted() config {
+	tokens := make([]apiToken, len(cfg.Tokens))
+	for i, token := range cfg.Tokens {
+		token.Token = "<redacted>"
+		tokens[i] = token
+	}
+	cfg.Tokens = tokens
+	if cfg.ShareURL != "" {
+		cfg.ShareURL = "<redacted>"
+	}
+	instances := make([]instanceConfig, len(cfg.Instances))
+	for i, instance := range cfg.Instances {
+		if instance.ShareURL != "" {
+			instance.ShareURL = "<redacted>"
+		}
+		instances[i] = instance
+	}
+	cfg.Instances = instances
+	return cfg
+}
+
+// Build the configuration from, in increasing priority: defaults, config file, environment, flags.
+// The share URL can also be given as the only argument.
+func loadConfig(args []string) (cfg *config, printConfig bool, err error) {
+	cfg = defaultConfig()
+
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv("CLIPREMOTE_CONFIG"), "YAML or TOML config file")
+	fs.BoolVar(&printConfig, "print-config", false, "Print the effective configuration and exit")
+	listen := fs.String("listen", "", "Address to serve HTTP on. Only reachable from this machine by default")
+	tlsCert := fs.String("tls-cert", "", "TLS certificate file, to serve HTTPS")
+	tlsKey := fs.String("tls-key", "", "TLS key file, to serve HTTPS")
+	logLevel := fs.String("log-level", "", "Log level (debug, info, warn, error)")
+	logFormat := fs.String("log-format", "", "Log format (text, json)")
+	sessionFile := fs.String("session", "", "File to keep the session in, so restarts don't need a new share URL")
+	tokensFile := fs.String("tokens", "", "JSON file with the API tokens allowed to use the HTTP server")
+	unauthenticated := fs.Bool("unauthenticated", false, "Serve without API tokens even on addresses other machines can reach")
+	heartbeat := fs.Duration("heartbeat", 0, "Idle time after which a heartbeat is sent to CSP")
+	endpoints := fs.String("endpoints", "", "Comma-separated endpoints to enable ("+strings.Join(allEndpoints, ", ")+")")
+	stdio := fs.Bool("stdio", false, "Serve JSON-RPC over stdin/stdout instead of HTTP")
+	mcp := fs.Bool("mcp", false, "Serve the Model Context Protocol over stdin/stdout instead of HTTP")
+	fs.Usage = func() {
+		println("Usage: server [options] [<Share URL>]")
+		fs.PrintDefaults()
+	}
+	if err = fs.Parse(args); err != nil {
+		return
+	}
+
+	if *configFile != "" {
+		if err = cfg.loadFile(*configFile); err != nil {
+			return
+		}
+	}
+	if err = cfg.loadEnv(); err != nil {
+		return
+	}
+
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "listen":
+			cfg.Listen = *listen
+		case "tls-cert":
+			cfg.TLS.Cert = *tlsCert
+		case "tls-key":
+			cfg.TLS.Key = *tlsKey
+		case "log-level":
+			cfg.Log.Level = *logLevel
+		case "log-format":
+			cfg.Log.Format = *logFormat
+		case "session":
+			cfg.SessionFile = *sessionFile
+		case "tokens":
+			cfg.TokensFile = *tokensFile
+		case "unauthenticated":
+			cfg.Unauthenticated = *unauthenticated
+		case "heartbeat":
+			cfg.HeartbeatInterval = duration(*heartbeat)
+		case "endpoints":
+			cfg.Endpoints = splitList(*endpoints)
+		case "stdio":
+			if *stdio {
+				cfg.Mode = "stdio"
+			}
+		case "mcp":
+			if *mcp {
+				cfg.Mode = "mcp"
+			}
+		}
+	})
+	switch fs.NArg() {
+	case 0:
+	case 1:
+		cfg.ShareURL = fs.Arg(0)
+	default:
+		fs.Usage()
+		return nil, false, errors.New("too many arguments")
+	}
+
+	if cfg.TokensFile != "" {
+		auth, err := loadAuthConfig(cfg.TokensFile)
+		if err != nil {
+			return nil, false, err
+		}
+		cfg.Tokens = append(cfg.Tokens, auth.Tokens...)
+	}
+
+	if printConfig {
+		return cfg, true, nil // Print even if invalid, to help figure out why
+	}
+	return cfg, false, cfg.validate()
+}
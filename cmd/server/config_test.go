@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(file, []byte("listen: 127.0.0.1:1\nheartbeat_interval: 5s\nendpoints: [request]\nlog:\n  level: debug\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CLIPREMOTE_CONFIG", file)
+	t.Setenv("CLIPREMOTE_LISTEN", "127.0.0.1:2")
+	t.Setenv("CLIPREMOTE_ENDPOINTS", "request,events")
+
+	cfg, _, err := loadConfig([]string{"-listen", "127.0.0.1:3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Listen != "127.0.0.1:3" {
+		t.Errorf("listen: got %s, flag should win", cfg.Listen)
+	}
+	if strings.Join(cfg.Endpoints, ",") != "request,events" {
+		t.Errorf("endpoints: got %v, environment should win", cfg.Endpoints)
+	}
+	if time.Duration(cfg.HeartbeatInterval) != 5*time.Second || cfg.Log.Level != "debug" {
+		t.Errorf("got heartbeat %v and log level %s from the file", time.Duration(cfg.HeartbeatInterval), cfg.Log.Level)
+	}
+	if cfg.Log.Format != "text" {
+		t.Errorf("log format: got %s, want the default", cfg.Log.Format)
+	}
+}
+
+func TestValidateUnauthenticated(t *testing.T) {
+	tests := []struct {
+		listen          string
+		tokens          []apiToken
+		unauthenticated bool
+		mode            string
+		ok              bool
+	}{
+		{"127.0.0.1:8089", nil, false, "http", true},
+		{"[::1]:8089", nil, false, "http", true},
+		{"localhost:8089", nil, false, "http", true},
+		{":8089", nil, false, "http", false},
+		{"0.0.0.0:8089", nil, false, "http", false},
+		{"192.168.1.2:8089", nil, false, "http", false},
+		{"192.168.1.2:8089", []apiToken{{Name: "admin", Token: "0123456789abcdef"}}, false, "http", true},
+		{"192.168.1.2:8089", nil, true, "http", true},
+		{":8089", nil, false, "stdio", true},
+	}
+	for _, tt := range tests {
+		cfg := defaultConfig()
+		cfg.Listen, cfg.Tokens, cfg.Unauthenticated, cfg.Mode = tt.listen, tt.tokens, tt.unauthenticated, tt.mode
+		if tt.mode != "http" {
+			cfg.ShareURL = "https://companion.clip-studio.com/rc/en-us?s=test"
+		}
+		if err := cfg.validate(); (err == nil) != tt.ok {
+			t.Errorf("%s with %d tokens, unauthenticated %v, mode %s: got %v", tt.listen, len(tt.tokens), tt.unauthenticated, tt.mode, err)
+		}
+	}
+}
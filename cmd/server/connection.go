@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/chocolatkey/clipremote"
+	"github.com/chocolatkey/clipremote/pkg/commands"
+	"github.com/chocolatkey/clipremote/pkg/packets"
+	"github.com/chocolatkey/clipremote/pkg/preview"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// How long commands sent to a replaced client get to finish before it's closed.
+const drainTimeout = 10 * time.Second
+
+// Sent by CSP on its own when the webtoon preview changes.
+const previewWebtoonFromServer = commands.Command("PreviewWebtoonFromServer")
+
+// Something client events can be subscribed to.
+type eventSource interface {
+	Subscribe(buffer int) (<-chan clipremote.Event, func())
+}
+
+// The CSP connection used by the server. Pairing again with a new share URL swaps in a new
+// client, so handlers should get the client with Client for every request instead of keeping it.
+type connection struct {
+	name          string // Of the instance
+	current       atomic.Pointer[clipremote.Client]
+	pairing       sync.Mutex   // Held while a new client is connected
+	pairedAt      atomic.Int64 // Unix nanoseconds
+	clientOptions []clipremote.ClientOption
+	sessionFile   string
+	gallery       *galleryState
+	cache         *preview.BlockCache
+	stopRelay     func() // Stops relaying the current client's events
+
+	subMu sync.Mutex
+	subs  map[chan clipremote.Event]struct{}
+}
+
+func newConnection(name string, cfg *config, sessionFile string, cache *preview.BlockCache) *connection {
+	logger := logrus.WithField("instance", name)
+	return &connection{
+		name: name,
+		clientOptions: []clipremote.ClientOption{
+			clipremote.WithHeartbeatInterval(time.Duration(cfg.HeartbeatInterval)),
+			clipremote.WithRequestTimeout(time.Duration(cfg.Timeouts.Request)),
+			clipremote.WithLogger(logger),
+			clipremote.WithInterceptors(clipremote.LoggingInterceptor(logger)),
+		},
+		sessionFile: sessionFile,
+		gallery:     &galleryState{},
+		cache:       cache.Scope(name),
+		subs:        make(map[chan clipremote.Event]struct{}),
+	}
+}
+
+// Current client.
+func (c *connection) Client() *clipremote.Client {
+	return c.current.Load()
+}
+
+// Subscribe to the events of whichever client is current, see Client.Subscribe.
+func (c *connection) Subscribe(buffer int) (<-chan clipremote.Event, func()) {
+	ch := make(chan clipremote.Event, buffer)
+	c.subMu.Lock()
+	c.subs[ch] = struct{}{}
+	c.subMu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			c.subMu.Lock()
+			defer c.subMu.Unlock()
+			delete(c.subs, ch)
+			close(ch)
+		})
+	}
+}
+
+// Pass the client's events on to the connection's subscribers, until unsubscribed.
+func (c *connection) relay(client *clipremote.Client) func() {
+	events, unsubscribe := client.Subscribe(64)
+	go func() {
+		for event := range events {
+			if event.Type == clipremote.EventPacket {
+				c.canvasChanged(event.Packet)
+			}
+			c.subMu.Lock()
+			for ch := range c.subs {
+				select {
+				case ch <- event:
+				default:
+				}
+			}
+			c.subMu.Unlock()
+		}
+	}()
+	return unsubscribe
+}
+
+// Forget what's known of a canvas CSP says was reset, or of all of them for anything else
+// it sends about the preview.
+func (c *connection) canvasChanged(scp *packets.ServerCommand) {
+	if scp == nil || scp.Command != previewWebtoonFromServer {
+		return
+	}
+	c.gallery.Reset() // Canvas sizes may have changed too
+	var detail commands.DetailPreviewWebtoonFromServerResponse
+	bin, err := json.Marshal(scp.Detail)
+	if err == nil && json.Unmarshal(bin, &detail) == nil && detail.Operation == "ResetCanvas" {
+		c.cache.ClearCanvas(detail.CanvasIndex)
+		return
+	}
+	c.cache.Clear()
+}
+
+// Connect and authenticate a client for the session, then swap it in for the current one.
+// The current client is kept if anything fails. The replaced client is closed once the
+// commands sent to it are done, or after drainTimeout.
+func (c *connection) Pair(session *clipremote.Session) error {
+	c.pairing.Lock()
+	defer c.pairing.Unlock()
+
+	logrus.Infoln("share generation", session.Generation)
+	client, err := clipremote.ConnectSession(context.Background(), session, c.clientOptions...)
+	if err != nil {
+		return errors.Wrap(err, "failed connecting to CSP instance")
+	}
+	logrus.Infoln("client authenticated")
+	unsubscribe := c.relay(client)
+
+	if c.sessionFile != "" {
+		// The password was just changed, so the old one won't work anymore
+		if err := client.Session().Save(c.sessionFile); err != nil {
+			logrus.Errorln(err)
+		}
+	}
+
+	old := c.current.Swap(client)
+	c.pairedAt.Store(time.Now().UnixNano())
+	stopRelay := c.stopRelay
+	c.stopRelay = unsubscribe
+	c.gallery.Reset()
+	c.cache.Clear()
+	if old != nil {
+		logrus.Infoln("replaced connection to", old.RemoteAddr(), "with", client.RemoteAddr())
+		stopRelay() // Subscribers would think the new client disconnected
+		go retire(old)
+	}
+	return nil
+}
+
+// Stop using the connection. The client is closed once the commands sent to it are done.
+func (c *connection) Close() {
+	c.pairing.Lock()
+	defer c.pairing.Unlock()
+	if c.stopRelay != nil {
+		c.stopRelay()
+		c.stopRelay = nil
+	}
+	if client := c.Client(); client != nil {
+		go retire(client)
+	}
+	c.cache.Clear()
+}
+
+type connectionHealth struct {
+	Name          string       `json:"name"`
+	Alive         bool         `json:"alive"`
+	RemoteAddress string       `json:"remote_address,omitempty"`
+	Generation    string       `json:"generation,omitempty"`
+	Pending       int          `json:"pending"` // Commands waiting for a response
+	PairedAt      time.Time    `json:"paired_at"`
+	Dials         []dialHealth `json:"dials,omitempty"` // How connecting to each address went
+}
+
+type dialHealth struct {
+	Address     string `json:"address"`
+	Latency     string `json:"latency,omitempty"`
+	AuthLatency string `json:"auth_latency,omitempty"`
+	Error       string `json:"error,omitempty"`
+	Won         bool   `json:"won,omitempty"`
+}
+
+func durationString(d time.Duration) string {
+	if d == 0 {
+		return ""
+	}
+	return d.Round(time.Microsecond).String()
+}
+
+func (c *connection) Health() connectionHealth {
+	health := connectionHealth{Name: c.name, PairedAt: time.Unix(0, c.pairedAt.Load())}
+	if client := c.Client(); client != nil {
+		health.Alive = client.Alive()
+		health.RemoteAddress = client.RemoteAddr()
+		health.Generation = client.Session().Generation
+		health.Pending = client.Pending()
+		for _, attempt := range client.DialAttempts() {
+			dial := dialHealth{
+				Address:     attempt.Address,
+				Latency:     durationString(attempt.Latency),
+				AuthLatency: durationString(attempt.AuthLatency),
+				Won:         attempt.Won,
+			}
+			if attempt.Err != nil {
+				dial.Error = attempt.Err.Error()
+			}
+			health.Dials = append(health.Dials, dial)
+		}
+	}
+	return health
+}
+
+// Health without where CSP is and how it was reached, unless the token is an admin's.
+func (h connectionHealth) forToken(token *apiToken) connectionHealth {
+	if !token.IsAdmin() {
+		h.RemoteAddress, h.Generation, h.Dials = "", "", nil
+	}
+	return h
+}
+
+// Close a replaced client once it has nothing left to do.
+func retire(client *clipremote.Client) {
+	deadline := time.Now().Add(drainTimeout)
+	for client.Pending() > 0 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if err := client.Close(); err != nil {
+		logrus.Debugln("failed closing replaced client", err)
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"image"
+	"testing"
+
+	"github.com/chocolatkey/clipremote/pkg/commands"
+	"github.com/chocolatkey/clipremote/pkg/packets"
+	"github.com/chocolatkey/clipremote/pkg/preview"
+)
+
+func TestCanvasChanged(t *testing.T) {
+	tests := []struct {
+		name   string
+		packet *packets.ServerCommand
+		left   int
+	}{
+		{"canvas reset", &packets.ServerCommand{Command: previewWebtoonFromServer, Detail: map[string]interface{}{"Operation": "ResetCanvas", "CanvasIndex": 1.0}}, 2},
+		{"unknown operation", &packets.ServerCommand{Command: previewWebtoonFromServer, Detail: map[string]interface{}{"Operation": "Other"}}, 0},
+		{"other command", &packets.ServerCommand{Command: commands.GetServerSelectedTabKind}, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := newConnection("default", defaultConfig(), "", preview.NewBlockCache(10))
+			conn.gallery.current = &commands.DetailPreviewWebtoonFromClientResponseUpdateGallery{}
+			img := image.NewRGBA(image.Rect(0, 0, 1, 1))
+			for _, canvasIndex := range []uint{0, 0, 1} {
+				conn.cache.Put(preview.BlockKey{Source: "default", CanvasIndex: canvasIndex, BlockIndex: uint(conn.cache.Len())}, img)
+			}
+			conn.canvasChanged(tt.packet)
+			if got := conn.cache.Len(); got != tt.left {
+				t.Errorf("got %d blocks left, want %d", got, tt.left)
+			}
+			if reset := conn.gallery.current == nil; reset != (tt.left < 3) {
+				t.Errorf("gallery reset: %v", reset)
+			}
+		})
+	}
+}
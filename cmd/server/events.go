@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chocolatkey/clipremote"
+	"github.com/chocolatkey/clipremote/pkg/commands"
+	"github.com/chocolatkey/clipremote/pkg/packets"
+	"github.com/sirupsen/logrus"
+)
+
+type loggedEvent struct {
+	ID   uint64
+	Name string
+	Data []byte // JSON
+
+	// What the event is about, so it's only streamed to tokens allowed to send it
+	Command commands.Command
+	Detail  interface{}
+}
+
+// Recent activity, kept in a ring buffer so SSE clients can resume with Last-Event-ID.
+// Event IDs are "<epoch>-<number>", the epoch telling apart the IDs of each run of the server.
+type eventLog struct {
+	epoch   string
+	mu      sync.Mutex
+	events  []loggedEvent
+	start   int    // Index of the oldest event in the ring
+	nextID  uint64 // IDs start at 1 so 0 can mean "nothing seen yet"
+	waiting chan struct{}
+}
+
+func newEventLog(size int) *eventLog {
+	return &eventLog{
+		epoch:   strconv.FormatInt(time.Now().UnixNano(), 36),
+		events:  make([]loggedEvent, 0, size),
+		nextID:  1,
+		waiting: make(chan struct{}),
+	}
+}
+
+// Client event, and the instance it's from.
+type instanceEvent struct {
+	Instance string `json:"instance"`
+	clipremote.Event
+}
+
+// Log the events of an instance. Call the returned function to stop.
+func (l *eventLog) Follow(source eventSource, instance string) func() {
+	events, unsubscribe := source.Subscribe(64)
+	go func() {
+		for event := range events {
+			var command commands.Command
+			var detail interface{}
+			if event.Packet != nil {
+				command, detail = event.Packet.Command, event.Packet.Detail
+			}
+			l.add(string(event.Type), instanceEvent{Instance: instance, Event: event}, command, detail)
+		}
+	}()
+	return unsubscribe
+}
+
+// Summary of a command sent through the API.
+type commandSummary struct {
+	Source   string           `json:"source"` // Endpoint the command came from
+	Instance string           `json:"instance"`
+	Command  commands.Command `json:"command"`
+	Serial   *packets.Serial  `json:"serial,omitempty"`
+	Type     string           `json:"type,omitempty"` // Response type, see ServerCommand.MarshalJSON
+	Error    string           `json:"error,omitempty"`
+	Duration float64          `json:"duration_ms"`
+}
+
+func (l *eventLog) AddCommand(source string, instance string, command commands.Command, detail interface{}, started time.Time, scp *packets.ServerCommand, err error) {
+	summary := commandSummary{
+		Source:   source,
+		Instance: instance,
+		Command:  command,
+		Duration: float64(time.Since(started).Microseconds()) / 1000,
+	}
+	if scp != nil {
+		summary.Serial = &scp.Serial
+		switch scp.Type {
+		case packets.TypeServerResponseSuccess:
+			summary.Type = "success"
+		case packets.TypeServerResponseError:
+			summary.Type = "error"
+		}
+	}
+	if err != nil {
+		summary.Error = err.Error()
+	}
+	l.add("command", summary, command, detail)
+}
+
+func (l *eventLog) add(name string, v interface{}, command commands.Command, detail interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		logrus.Warnln("failed encoding event", name, err)
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	event := loggedEvent{ID: l.nextID, Name: name, Data: data, Command: command, Detail: detail}
+	l.nextID++
+	if len(l.events) < cap(l.events) {
+		l.events = append(l.events, event)
+	} else {
+		l.events[l.start] = event
+		l.start = (l.start + 1) % len(l.events)
+	}
+
+	// Wake up everyone waiting for new events
+	close(l.waiting)
+	l.waiting = make(chan struct{})
+}
+
+// Events after the given ID that are still in the buffer, and a channel closed when there are new ones.
+func (l *eventLog) Since(id uint64) ([]loggedEvent, <-chan struct{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var events []loggedEvent
+	for i := 0; i < len(l.events); i++ {
+		event := l.events[(l.start+i)%len(l.events)]
+		if event.ID > id {
+			events = append(events, event)
+		}
+	}
+	return events, l.waiting
+}
+
+// Latest event ID, so new SSE clients only get what happens after they connect.
+func (l *eventLog) LastID() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.nextID - 1
+}
+
+// Whether the token may see the event, which it can only if it may send the command.
+func (e *loggedEvent) visibleTo(token *apiToken) bool {
+	return e.Command == "" || token.Allows(e.Command, e.Detail)
+}
+
+// Streams the event log as Server-Sent Events, leaving out commands the token can't send.
+func eventsHandler(log *eventLog) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+			return
+		}
+
+		lastID := log.LastID()
+		if header := r.Header.Get("Last-Event-ID"); header != "" {
+			epoch, number, _ := strings.Cut(header, "-")
+			if epoch == log.epoch {
+				id, err := strconv.ParseUint(number, 10, 64)
+				if err != nil {
+					http.Error(w, "Invalid Last-Event-ID", http.StatusBadRequest)
+					return
+				}
+				lastID = id
+			} else {
+				lastID = 0 // Seen before the server restarted, so everything is new
+			}
+		}
+
+		w.Header().Set("content-type", "text/event-stream")
+		w.Header().Set("cache-control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		token := tokenFrom(r.Context())
+		keepalive := time.NewTicker(15 * time.Second)
+		defer keepalive.Stop()
+		for {
+			events, wait := log.Since(lastID)
+			for _, event := range events {
+				lastID = event.ID
+				if !event.visibleTo(token) {
+					continue
+				}
+				fmt.Fprintf(w, "id: %s-%d\nevent: %s\ndata: %s\n\n", log.epoch, event.ID, event.Name, event.Data)
+			}
+			flusher.Flush()
+
+			select {
+			case <-r.Context().Done():
+				return
+			case <-wait:
+			case <-keepalive.C:
+				fmt.Fprint(w, ": keepalive\n\n")
+			}
+		}
+	}
+}
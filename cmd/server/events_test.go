@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chocolatkey/clipremote"
+	"github.com/chocolatkey/clipremote/pkg/commands"
+)
+
+func TestEventsFilteredByToken(t *testing.T) {
+	log := newEventLog(16)
+	log.AddCommand("rest", "default", commands.GetServerSelectedTabKind, nil, time.Now(), nil, nil)
+	log.AddCommand("rest", "default", commands.PreviewWebtoonFromClient, map[string]interface{}{"Operation": "UpdateGallery"}, time.Now(), nil, nil)
+	log.add("packet", instanceEvent{Instance: "default", Event: clipremote.Event{Type: clipremote.EventPacket}}, commands.PreviewWebtoonFromClient, map[string]interface{}{"Operation": "ReadPreviewBlock"})
+	log.add("disconnected", instanceEvent{Instance: "default", Event: clipremote.Event{Type: clipremote.EventDisconnected}}, "", nil)
+
+	tests := []struct {
+		name  string
+		token *apiToken
+		want  []string
+	}{
+		{"authentication disabled", nil, []string{"1", "2", "3", "4"}},
+		{"allowed commands", &apiToken{Allow: []string{"GetServerSelectedTabKind"}}, []string{"1", "4"}},
+		{"denied operation", &apiToken{Deny: []string{"PreviewWebtoonFromClient/UpdateGallery"}}, []string{"1", "3", "4"}},
+	}
+	for _, tt := range tests {
+		ctx, cancel := context.WithTimeout(context.WithValue(context.Background(), tokenContextKey{}, tt.token), 20*time.Millisecond)
+		r := httptest.NewRequest(http.MethodGet, "/events", nil).WithContext(ctx)
+		r.Header.Set("Last-Event-ID", log.epoch+"-0")
+		w := httptest.NewRecorder()
+		eventsHandler(log)(w, r)
+		cancel()
+
+		var ids []string
+		for _, line := range strings.Split(w.Body.String(), "\n") {
+			if id := strings.TrimPrefix(line, "id: "+log.epoch+"-"); id != line {
+				ids = append(ids, id)
+			}
+		}
+		if strings.Join(ids, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("%s: got events %v, want %v", tt.name, ids, tt.want)
+		}
+	}
+}
+
+func TestEventsResume(t *testing.T) {
+	log := newEventLog(4)
+	for i := 0; i < 6; i++ {
+		log.add("command", i, "", nil)
+	}
+	tests := []struct {
+		lastEventID string
+		status      int
+		want        string // IDs of the events sent
+	}{
+		{"", http.StatusOK, ""},
+		{log.epoch + "-4", http.StatusOK, "5,6"},
+		{log.epoch + "-6", http.StatusOK, ""},
+		{log.epoch + "-1", http.StatusOK, "3,4,5,6"},       // Only the last 4 are kept
+		{"0" + log.epoch + "-4", http.StatusOK, "3,4,5,6"}, // From before a restart
+		{"4", http.StatusOK, "3,4,5,6"},
+		{log.epoch + "-x", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		r := httptest.NewRequest(http.MethodGet, "/events", nil).WithContext(ctx)
+		if tt.lastEventID != "" {
+			r.Header.Set("Last-Event-ID", tt.lastEventID)
+		}
+		w := httptest.NewRecorder()
+		eventsHandler(log)(w, r)
+		cancel()
+
+		if w.Code != tt.status {
+			t.Errorf("%q: got status %d, want %d", tt.lastEventID, w.Code, tt.status)
+			continue
+		}
+		var ids []string
+		for _, line := range strings.Split(w.Body.String(), "\n") {
+			if id := strings.TrimPrefix(line, "id: "+log.epoch+"-"); id != line {
+				ids = append(ids, id)
+			}
+		}
+		if tt.status == http.StatusOK && strings.Join(ids, ",") != tt.want {
+			t.Errorf("%q: got events %v, want %s", tt.lastEventID, ids, tt.want)
+		}
+	}
+}
+
+func TestHealthForToken(t *testing.T) {
+	health := connectionHealth{Name: "default", Alive: true, RemoteAddress: "192.168.1.2:50000", Generation: "1", Dials: []dialHealth{{Address: "192.168.1.2"}}}
+	if got := health.forToken(&apiToken{Admin: true}); got.RemoteAddress == "" || got.Generation == "" || got.Dials == nil {
+		t.Errorf("admin got %+v", got)
+	}
+	if got := health.forToken(nil); got.RemoteAddress == "" {
+		t.Errorf("got %+v without authentication", got)
+	}
+	got := health.forToken(&apiToken{})
+	if got.RemoteAddress != "" || got.Generation != "" || got.Dials != nil {
+		t.Errorf("viewer got %+v", got)
+	}
+	if !got.Alive || got.Name != "default" {
+		t.Errorf("viewer lost the state: %+v", got)
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/chocolatkey/clipremote/pkg/commands"
+	"github.com/chocolatkey/clipremote/pkg/preview"
+)
+
+// TODO figure out what MaxLength actually limits. Matching the block height works.
+const galleryMaxLength = preview.BlockHeight
+
+// Gallery last returned by the server, shared by endpoints that need to know the canvas sizes.
+type galleryState struct {
+	mu      sync.Mutex
+	current *commands.DetailPreviewWebtoonFromClientResponseUpdateGallery
+}
+
+// Get the gallery, asking the server for it through s if there's none yet or refresh is requested.
+func (g *galleryState) Get(s preview.Sender, refresh bool) (*commands.DetailPreviewWebtoonFromClientResponseUpdateGallery, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.current != nil && !refresh {
+		return g.current, nil
+	}
+	gallery, err := preview.UpdateGallery(s, galleryMaxLength)
+	if err != nil {
+		return nil, err
+	}
+	g.current = gallery
+	return gallery, nil
+}
+
+// Forget the gallery, for when it belongs to a connection that's gone.
+func (g *galleryState) Reset() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.current = nil
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"image"
+	"image/png"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/chocolatkey/clipremote/pkg/commands"
+	"github.com/chocolatkey/clipremote/pkg/preview"
+	"github.com/chocolatkey/clipremote/pkg/webtoon"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/image/bmp"
+)
+
+// Most blocks a canvas request may have in flight, since each holds a block in memory.
+const maxFetchConcurrency = 16
+
+func toUint(s string) (uint, error) {
+	if s == "" {
+		return 0, errors.New("empty")
+	}
+	num, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return uint(num), nil
+}
+
+// Send any command, with the detail as JSON in form data.
+func requestHandler(conn *connection, activity *eventLog) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "Bad request body", http.StatusBadRequest)
+			return
+		}
+		if !conn.Client().Alive() {
+			http.Error(w, "Not ready", http.StatusServiceUnavailable)
+			return
+		}
+
+		// Form data
+		command := r.Form.Get("command")
+		detail := r.Form.Get("detail")
+		if command == "" {
+			http.Error(w, "Missing command", http.StatusBadRequest)
+			return
+		}
+
+		var detailData interface{}
+		if len(detail) > 2 {
+			var detailDataArray []interface{}
+			if err := json.Unmarshal([]byte(detail), &detailDataArray); err != nil {
+				detailDataMap := make(map[string]interface{})
+				if err := json.Unmarshal([]byte(detail), &detailDataMap); err != nil {
+					http.Error(w, "Invalid data, must be valid JSON if included", http.StatusBadRequest)
+					return
+				} else {
+					detailData = detailDataMap
+				}
+			} else {
+				detailData = detailDataArray
+			}
+		}
+
+		started := time.Now()
+		scp, err := guard(conn, r.Context()).SendCommandSync(commands.Command(command), detailData)
+		activity.AddCommand("request", conn.name, commands.Command(command), detailData, started, scp, err)
+		if err != nil {
+			writeError(w, err, http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("content-type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(scp)
+	}
+}
+
+// Read a single preview block as BMP.
+func previewHandler(conn *connection) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "Bad request body", http.StatusBadRequest)
+			return
+		}
+
+		blockIndex, err := toUint(r.FormValue("block_index"))
+		if err != nil {
+			http.Error(w, "Invalid/empty block_index", http.StatusBadRequest)
+			return
+		}
+
+		blockBottom, err := toUint(r.FormValue("block_bottom"))
+		if err != nil {
+			http.Error(w, "Invalid/empty block_bottom", http.StatusBadRequest)
+			return
+		}
+
+		blockRight, err := toUint(r.FormValue("block_right"))
+		if err != nil {
+			http.Error(w, "Invalid/empty block_right", http.StatusBadRequest)
+			return
+		}
+
+		blockTop, err := toUint(r.FormValue("block_top"))
+		if err != nil {
+			http.Error(w, "Invalid/empty block_top", http.StatusBadRequest)
+			return
+		}
+
+		blockLeft, err := toUint(r.FormValue("block_left"))
+		if err != nil {
+			http.Error(w, "Invalid/empty block_left", http.StatusBadRequest)
+			return
+		}
+
+		canvasIndex, err := toUint(r.FormValue("canvas_index"))
+		if err != nil {
+			http.Error(w, "Invalid/empty canvas_index", http.StatusBadRequest)
+			return
+		}
+
+		galleryIdentificationNumber, err := toUint(r.FormValue("gallery_identification_number"))
+		if err != nil {
+			http.Error(w, "Invalid/empty gallery_identification_number", http.StatusBadRequest)
+			return
+		}
+
+		img, err := preview.ReadBlock(
+			guard(conn, r.Context()),
+			galleryIdentificationNumber,
+			canvasIndex,
+			blockIndex,
+			image.Rect(int(blockLeft), int(blockTop), int(blockRight), int(blockBottom)),
+		)
+		if err != nil {
+			writeError(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		// Render preview as BMP
+		w.Header().Set("content-type", "image/bmp")
+		w.WriteHeader(http.StatusOK)
+		bmp.Encode(w, img)
+	}
+}
+
+// Detect the panels of a canvas, or get one of them as PNG.
+func panelsHandler(conn *connection) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "Bad request body", http.StatusBadRequest)
+			return
+		}
+
+		canvasIndex, err := toUint(r.FormValue("canvas_index"))
+		if err != nil {
+			http.Error(w, "Invalid/empty canvas_index", http.StatusBadRequest)
+			return
+		}
+
+		// The gallery may come from the state, which the guard doesn't see
+		c := guard(conn, r.Context())
+		for _, detail := range []interface{}{updateGalleryDetail, readPreviewBlockDetail} {
+			if err := c.allow(commands.PreviewWebtoonFromClient, detail); err != nil {
+				writeError(w, err, http.StatusForbidden)
+				return
+			}
+		}
+		gallery, err := conn.gallery.Get(c, r.FormValue("refresh") != "")
+		if err != nil {
+			writeError(w, err, http.StatusInternalServerError)
+			return
+		}
+		if canvasIndex >= uint(len(gallery.CanvasSizeArray)) {
+			http.Error(w, "Canvas not found", http.StatusNotFound)
+			return
+		}
+
+		size := gallery.CanvasSizeArray[canvasIndex]
+		canvas, err := preview.ReadCanvas(c, gallery.GalleryIdentificationNumber, canvasIndex, size, preview.DefaultFetchOptions)
+		if err != nil {
+			writeError(w, err, http.StatusInternalServerError)
+			return
+		}
+		panels := webtoon.DetectPanels(canvas, webtoon.DefaultDetectOptions)
+
+		// A single panel as an image
+		if r.FormValue("panel") != "" {
+			panelIndex, err := toUint(r.FormValue("panel"))
+			if err != nil {
+				http.Error(w, "Invalid panel", http.StatusBadRequest)
+				return
+			}
+			if panelIndex >= uint(len(panels)) {
+				http.Error(w, "Panel not found", http.StatusNotFound)
+				return
+			}
+			w.Header().Set("content-type", "image/png")
+			w.WriteHeader(http.StatusOK)
+			png.Encode(w, canvas.SubImage(panels[panelIndex].Bounds()))
+			return
+		}
+
+		w.Header().Set("content-type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"gallery_identification_number": gallery.GalleryIdentificationNumber,
+			"canvas_index":                  canvasIndex,
+			"width":                         size.CanvasWidth,
+			"height":                        size.CanvasHeight,
+			"panels":                        panels,
+		})
+	}
+}
+
+// Stream a whole canvas as PNG.
+func canvasHandler(conn *connection) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "Bad request body", http.StatusBadRequest)
+			return
+		}
+
+		canvasIndex, err := toUint(r.FormValue("canvas_index"))
+		if err != nil {
+			http.Error(w, "Invalid/empty canvas_index", http.StatusBadRequest)
+			return
+		}
+
+		// The gallery may come from the state, and the blocks should all be allowed before the response starts
+		c := guard(conn, r.Context())
+		for _, detail := range []interface{}{updateGalleryDetail, readPreviewBlockDetail} {
+			if err := c.allow(commands.PreviewWebtoonFromClient, detail); err != nil {
+				writeError(w, err, http.StatusForbidden)
+				return
+			}
+		}
+		current, err := conn.gallery.Get(c, r.FormValue("refresh") != "")
+		if err != nil {
+			writeError(w, err, http.StatusInternalServerError)
+			return
+		}
+		if canvasIndex >= uint(len(current.CanvasSizeArray)) {
+			http.Error(w, "Canvas not found", http.StatusNotFound)
+			return
+		}
+
+		opts := preview.DefaultFetchOptions
+		if r.FormValue("concurrency") != "" {
+			concurrency, err := toUint(r.FormValue("concurrency"))
+			if err != nil || concurrency == 0 {
+				http.Error(w, "Invalid concurrency", http.StatusBadRequest)
+				return
+			}
+			if concurrency > maxFetchConcurrency {
+				concurrency = maxFetchConcurrency
+			}
+			opts.Concurrency = int(concurrency)
+		}
+		opts.Progress = func(done, total int) {
+			logrus.Debugf("canvas %d: %d/%d blocks", canvasIndex, done, total)
+		}
+
+		// The canvas is streamed as it's read, so errors after the first block can only cut the response short
+		res := &pngResponse{ResponseWriter: w}
+		if err := preview.StreamCanvas(res, c, current.GalleryIdentificationNumber, canvasIndex, current.CanvasSizeArray[canvasIndex], opts); err != nil {
+			if !res.started {
+				writeError(w, err, http.StatusInternalServerError)
+				return
+			}
+			logrus.Errorln("failed streaming canvas", canvasIndex, err)
+		}
+	}
+}
+
+// Response only sending its status and headers with the first write, so errors before
+// that still get their own status.
+type pngResponse struct {
+	http.ResponseWriter
+	started bool
+}
+
+func (r *pngResponse) Write(p []byte) (int, error) {
+	if !r.started {
+		r.started = true
+		r.Header().Set("content-type", "image/png")
+		r.WriteHeader(http.StatusOK)
+	}
+	return r.ResponseWriter.Write(p)
+}
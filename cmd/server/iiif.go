@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/chocolatkey/clipremote/pkg/commands"
+	"github.com/chocolatkey/clipremote/pkg/iiif"
+	"github.com/chocolatkey/clipremote/pkg/preview"
+)
+
+// Serves gallery canvases over the IIIF Image API, identified by their canvas index:
+// /iiif/{canvas}/info.json and /iiif/{canvas}/{region}/{size}/{rotation}/{quality}.{format}
+func iiifHandler(conn *connection, limits iiif.Limits) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c := guard(conn, r.Context())
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("access-control-allow-origin", "*")
+
+		segments := strings.Split(strings.TrimPrefix(r.URL.Path, "/iiif/"), "/")
+		canvasIndex, err := toUint(segments[0])
+		if err != nil {
+			http.Error(w, "Invalid canvas identifier", http.StatusBadRequest)
+			return
+		}
+
+		// Base URI of the image without trailing slash should redirect to info.json
+		if len(segments) == 1 || (len(segments) == 2 && segments[1] == "") {
+			http.Redirect(w, r, mountPath(r)+"/iiif/"+segments[0]+"/info.json", http.StatusSeeOther)
+			return
+		}
+
+		// Viewers load info.json before any tiles, so that's when the gallery gets refreshed
+		isInfo := len(segments) == 2 && segments[1] == "info.json"
+		if err := c.allow(commands.PreviewWebtoonFromClient, updateGalleryDetail); err != nil {
+			writeError(w, err, http.StatusForbidden)
+			return
+		}
+		current, err := conn.gallery.Get(c, isInfo)
+		if err != nil {
+			writeError(w, err, http.StatusInternalServerError)
+			return
+		}
+		if canvasIndex >= uint(len(current.CanvasSizeArray)) {
+			http.Error(w, "Canvas not found", http.StatusNotFound)
+			return
+		}
+		size := current.CanvasSizeArray[canvasIndex]
+
+		if isInfo {
+			scheme := "http"
+			if r.TLS != nil {
+				scheme = "https"
+			}
+			info := iiif.NewInfo(
+				scheme+"://"+r.Host+mountPath(r)+"/iiif/"+strconv.FormatUint(uint64(canvasIndex), 10),
+				int(size.CanvasWidth), int(size.CanvasHeight),
+				int(size.CanvasWidth), preview.BlockHeight,
+				limits,
+			)
+			w.Header().Set("content-type", "application/ld+json;profile=\""+iiif.Context+"\"")
+			json.NewEncoder(w).Encode(info)
+			return
+		}
+
+		if len(segments) != 5 {
+			http.Error(w, "Invalid image request", http.StatusBadRequest)
+			return
+		}
+		req, err := iiif.ParseRequest(segments[1], segments[2], segments[3], segments[4], int(size.CanvasWidth), int(size.CanvasHeight), limits)
+		if err != nil {
+			status := http.StatusBadRequest
+			if errors.Is(err, iiif.ErrNotImplemented) {
+				status = http.StatusNotImplemented
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+
+		if err := c.allow(commands.PreviewWebtoonFromClient, readPreviewBlockDetail); err != nil {
+			writeError(w, err, http.StatusForbidden)
+			return
+		}
+		region, err := conn.cache.ReadRegion(c, current.GalleryIdentificationNumber, canvasIndex, size, req.Region)
+		if err != nil {
+			writeError(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("content-type", iiif.ContentType(req.Format))
+		w.WriteHeader(http.StatusOK)
+		iiif.Encode(w, req.Render(region), req.Format)
+	}
+}
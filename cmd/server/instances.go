@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/chocolatkey/clipremote"
+	"github.com/chocolatkey/clipremote/pkg/iiif"
+	"github.com/chocolatkey/clipremote/pkg/jsonrpc"
+	"github.com/chocolatkey/clipremote/pkg/preview"
+	"github.com/pkg/errors"
+)
+
+// Names instances can have, so they're safe to use in URLs.
+var instanceNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+var (
+	errInstanceExists      = errors.New("instance already exists")
+	errInvalidInstanceName = errors.New("instance name can only have letters, digits, - and _")
+)
+
+type instance struct {
+	conn       *connection
+	handler    http.Handler // Routes of the instance, without the /instances/{name} prefix
+	stopFollow func()
+}
+
+// The CSP instances the server is connected to, by name.
+type instanceSet struct {
+	mu        sync.RWMutex
+	instances map[string]*instance
+	names     []string   // In the order they were added
+	adding    sync.Mutex // Held while an instance is added, so names can't be taken twice
+	cfg       *config
+	cache     *preview.BlockCache // Shared by all instances
+	activity  *eventLog
+}
+
+func newInstanceSet(cfg *config, activity *eventLog) *instanceSet {
+	return &instanceSet{
+		instances: make(map[string]*instance),
+		cfg:       cfg,
+		cache:     preview.NewBlockCache(256),
+		activity:  activity,
+	}
+}
+
+// Connect to an instance and add it.
+func (s *instanceSet) Add(name string, session *clipremote.Session, sessionFile string) (*connection, error) {
+	if !instanceNamePattern.MatchString(name) {
+		return nil, errInvalidInstanceName
+	}
+	s.adding.Lock()
+	defer s.adding.Unlock()
+	if s.Get(name) != nil {
+		return nil, errInstanceExists
+	}
+
+	conn := newConnection(name, s.cfg, sessionFile, s.cache)
+	stopFollow := s.activity.Follow(conn, name)
+	if err := conn.Pair(session); err != nil {
+		stopFollow()
+		return nil, errors.Wrapf(err, "failed pairing instance %s", name)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.instances[name] = &instance{
+		conn:       conn,
+		handler:    instanceRoutes(conn, s.activity, s.cfg),
+		stopFollow: stopFollow,
+	}
+	s.names = append(s.names, name)
+	return conn, nil
+}
+
+// Disconnect from an instance and remove it.
+func (s *instanceSet) Remove(name string) bool {
+	s.mu.Lock()
+	inst, ok := s.instances[name]
+	if !ok {
+		s.mu.Unlock()
+		return false
+	}
+	delete(s.instances, name)
+	for i, n := range s.names {
+		if n == name {
+			s.names = append(s.names[:i], s.names[i+1:]...)
+			break
+		}
+	}
+	s.mu.Unlock()
+
+	// Closing can wait for pending commands, so the other instances stay reachable meanwhile
+	inst.stopFollow()
+	inst.conn.Close()
+	return true
+}
+
+func (s *instanceSet) get(name string) *instance {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.instances[name]
+}
+
+// Connection to the instance with the given name, nil if there's none.
+func (s *instanceSet) Get(name string) *connection {
+	if inst := s.get(name); inst != nil {
+		return inst.conn
+	}
+	return nil
+}
+
+func (s *instanceSet) fallback() *instance {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if inst, ok := s.instances[defaultInstance]; ok {
+		return inst
+	}
+	if len(s.names) > 0 {
+		return s.instances[s.names[0]]
+	}
+	return nil
+}
+
+// The instance named "default", or else the first one added. Nil if there are none.
+func (s *instanceSet) Default() *connection {
+	if inst := s.fallback(); inst != nil {
+		return inst.conn
+	}
+	return nil
+}
+
+// Health of every instance, as the token may see it.
+func (s *instanceSet) Health(token *apiToken) []connectionHealth {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	health := make([]connectionHealth, len(s.names))
+	for i, name := range s.names {
+		health[i] = s.instances[name].conn.Health().forToken(token)
+	}
+	return health
+}
+
+// Routes every instance has. The same routes at the root of the server go to the default instance.
+func instanceRoutes(conn *connection, activity *eventLog, cfg *config) http.Handler {
+	routes := []struct {
+		endpoint string
+		pattern  string
+		handler  http.Handler
+	}{
+		{"request", "/request", requestHandler(conn, activity)},
+		{"preview", "/preview", previewHandler(conn)},
+		{"panels", "/panels", panelsHandler(conn)},
+		{"canvas", "/canvas", canvasHandler(conn)},
+		{"iiif", "/iiif/", iiifHandler(conn, iiif.Limits{MaxArea: cfg.IIIF.MaxArea, MaxRegionArea: cfg.IIIF.MaxRegionArea})},
+		{"ws", "/ws", wsHandler(conn, activity, newUpgrader(cfg.AllowedOrigins))},
+		{"commands", "/commands/", restHandler(conn, activity)},
+		{"batch", "/batch", batchHandler(conn, activity)},
+		{"rpc", "/rpc", &jsonrpc.Server{Handler: rpcHandler(conn, activity)}},
+		{"admin", "/admin/pair", pairHandler(conn)},
+	}
+	mux := http.NewServeMux()
+	for _, route := range routes {
+		if cfg.endpointEnabled(route.endpoint) {
+			mux.Handle(route.pattern, route.handler)
+		}
+	}
+	return mux
+}
+
+type mountContextKey struct{}
+
+// Serve the handler under the prefix, with the prefix stripped from the request's path.
+func mount(prefix string, h http.Handler) http.Handler {
+	stripped := http.StripPrefix(prefix, h)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stripped.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), mountContextKey{}, prefix)))
+	})
+}
+
+// Path the handler serving the request is mounted under, like "/instances/studio-a".
+// Empty for the default instance's routes at the root.
+func mountPath(r *http.Request) string {
+	prefix, _ := r.Context().Value(mountContextKey{}).(string)
+	return prefix
+}
+
+// Serves the routes of the default instance.
+func (s *instanceSet) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	inst := s.fallback()
+	if inst == nil {
+		http.Error(w, "No instances", http.StatusServiceUnavailable)
+		return
+	}
+	inst.handler.ServeHTTP(w, r)
+}
+
+type addInstanceRequest struct {
+	Name     string `json:"name"`
+	ShareURL string `json:"share_url"`
+}
+
+// GET /instances lists the instances and their health, POST /instances adds one.
+// GET and DELETE /instances/{name} get the health of an instance and remove it.
+// Everything else under /instances/{name}/ goes to the instance's routes.
+func instancesHandler(s *instanceSet) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/instances"), "/")
+		if path == "" {
+			switch r.Method {
+			case http.MethodGet:
+				w.Header().Set("content-type", "application/json; charset=utf-8")
+				json.NewEncoder(w).Encode(s.Health(tokenFrom(r.Context())))
+			case http.MethodPost:
+				if !tokenFrom(r.Context()).IsAdmin() {
+					http.Error(w, "Token is not allowed to add instances", http.StatusForbidden)
+					return
+				}
+				body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+				if err != nil {
+					http.Error(w, "Bad request body", http.StatusBadRequest)
+					return
+				}
+				var req addInstanceRequest
+				if err := json.Unmarshal(body, &req); err != nil || req.Name == "" || req.ShareURL == "" {
+					http.Error(w, "Body must be {\"name\": ..., \"share_url\": ...}", http.StatusBadRequest)
+					return
+				}
+				session, err := clipremote.SessionFromURL(req.ShareURL)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				conn, err := s.Add(req.Name, session, "")
+				if err != nil {
+					switch {
+					case errors.Is(err, errInstanceExists):
+						http.Error(w, err.Error(), http.StatusConflict)
+					case errors.Is(err, errInvalidInstanceName):
+						http.Error(w, err.Error(), http.StatusBadRequest)
+					default:
+						writeError(w, err, http.StatusBadGateway)
+					}
+					return
+				}
+				w.Header().Set("content-type", "application/json; charset=utf-8")
+				w.WriteHeader(http.StatusCreated)
+				json.NewEncoder(w).Encode(conn.Health())
+			default:
+				w.Header().Set("allow", "GET, POST")
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			}
+			return
+		}
+
+		name, rest, _ := strings.Cut(path, "/")
+		inst := s.get(name)
+		if inst == nil {
+			http.Error(w, "Instance not found", http.StatusNotFound)
+			return
+		}
+		if rest != "" || strings.HasSuffix(r.URL.Path, "/") {
+			mount("/instances/"+name, inst.handler).ServeHTTP(w, r)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			health := inst.conn.Health().forToken(tokenFrom(r.Context()))
+			w.Header().Set("content-type", "application/json; charset=utf-8")
+			if !health.Alive {
+				w.WriteHeader(http.StatusServiceUnavailable) // For health checks
+			}
+			json.NewEncoder(w).Encode(health)
+		case http.MethodDelete:
+			if !tokenFrom(r.Context()).IsAdmin() {
+				http.Error(w, "Token is not allowed to remove instances", http.StatusForbidden)
+				return
+			}
+			s.Remove(name)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.Header().Set("allow", "GET, DELETE")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMountPath(t *testing.T) {
+	var prefix, path string
+	capture := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		prefix, path = mountPath(r), r.URL.Path
+	})
+	tests := []struct {
+		handler http.Handler
+		target  string
+		prefix  string
+		path    string
+	}{
+		{capture, "/iiif/0", "", "/iiif/0"},
+		{mount("/instances/studio-a", capture), "/instances/studio-a/iiif/0", "/instances/studio-a", "/iiif/0"},
+		{mount("/instances/studio-a", capture), "/instances/studio-a/iiif/%30/info.json?x=1", "/instances/studio-a", "/iiif/0/info.json"},
+		{mount("/instances/studio-a", capture), "/instances/studio-a/iiif/a%2Fb", "/instances/studio-a", "/iiif/a/b"},
+	}
+	for _, tt := range tests {
+		prefix, path = "unset", "unset"
+		tt.handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, tt.target, nil))
+		if prefix != tt.prefix || path != tt.path {
+			t.Errorf("%s: got %q and %q, want %q and %q", tt.target, prefix, path, tt.prefix, tt.path)
+		}
+	}
+}
@@ -1,197 +1,119 @@
 package main
 
 import (
-	"encoding/base64"
-	"encoding/json"
-	"errors"
+	"fmt"
 	"net/http"
 	"os"
-	"strconv"
+	"time"
 
 	"github.com/chocolatkey/clipremote"
-	"github.com/chocolatkey/clipremote/pkg/commands"
-	"github.com/chocolatkey/clipremote/pkg/packets"
-	"github.com/chocolatkey/clipremote/pkg/preview"
+	"github.com/chocolatkey/clipremote/pkg/jsonrpc"
 	"github.com/sirupsen/logrus"
-	"golang.org/x/image/bmp"
+	"gopkg.in/yaml.v3"
 )
 
-func main() {
-	if len(os.Args) == 1 {
-		println("Usage: server <Share URL>")
-		return
+func setupLogging(cfg *config) {
+	level, _ := logrus.ParseLevel(cfg.Log.Level) // Already validated
+	logrus.SetLevel(level)
+	if cfg.Log.Format == "json" {
+		logrus.SetFormatter(&logrus.JSONFormatter{})
 	}
+	if cfg.Mode != "http" {
+		logrus.SetOutput(os.Stderr) // Stdout is for the protocol
+	}
+}
 
-	// logrus.SetLevel(logrus.DebugLevel)
-
-	ipAddresses, port, password, generation, err := clipremote.DecodeConfig(os.Args[1])
-	logrus.Infoln("share generation", generation)
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "pair" {
+		os.Exit(pairCommand(os.Args[2:]))
+	}
 
-	// Connect
-	client, err := clipremote.Connect(
-		ipAddresses,
-		port,
-		generation,
-	)
+	cfg, printConfig, err := loadConfig(os.Args[1:])
 	if err != nil {
-		println("Failed connecting to CSP instance")
-		panic(err)
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
 	}
+	if printConfig {
+		yaml.NewEncoder(os.Stdout).Encode(cfg.redacted())
+		return
+	}
+	setupLogging(cfg)
 
-	// Auth
-	client.Authenticate(func(scp *packets.ServerCommand, err error) {
-		if err != nil {
-			if scp != nil {
-				bin, _ := json.Marshal(scp)
-				println(string(bin))
-			}
-			panic(err)
-		}
-
-		println("Client authenticated")
-	}, password)
-
-	http.HandleFunc("/request", func(w http.ResponseWriter, r *http.Request) {
-		if err := r.ParseForm(); err != nil {
-			http.Error(w, "Bad request body", http.StatusBadRequest)
-			return
-		}
-		if !client.Alive() {
-			http.Error(w, "Not ready", http.StatusServiceUnavailable)
-			return
-		}
-
-		// Form data
-		command := r.Form.Get("command")
-		detail := r.Form.Get("detail")
-		if command == "" {
-			http.Error(w, "Missing command", http.StatusBadRequest)
-			return
+	activity := newEventLog(1024)
+	instances := newInstanceSet(cfg, activity)
+	for _, ic := range cfg.instances() {
+		// A new share URL wins over the saved session
+		var session *clipremote.Session
+		if ic.ShareURL != "" {
+			session, err = clipremote.SessionFromURL(ic.ShareURL)
+		} else {
+			session, err = clipremote.LoadSession(ic.SessionFile)
 		}
-
-		var detailData interface{}
-		if len(detail) > 2 {
-			var detailDataArray []interface{}
-			if err = json.Unmarshal([]byte(detail), &detailDataArray); err != nil {
-				detailDataMap := make(map[string]interface{})
-				if err = json.Unmarshal([]byte(detail), &detailDataMap); err != nil {
-					http.Error(w, "Invalid data, must be valid JSON if included", http.StatusBadRequest)
-					return
-				} else {
-					detailData = detailDataMap
-				}
-			} else {
-				detailData = detailDataArray
-			}
+		if err == nil {
+			_, err = instances.Add(ic.Name, session, ic.SessionFile)
 		}
-
-		scp, err := client.SendCommandSync(commands.Command(command), detailData)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		w.Header().Set("content-type", "application/json; charset=utf-8")
-		json.NewEncoder(w).Encode(scp)
-	})
-
-	http.HandleFunc("/preview", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodGet {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-			return
-		}
-		if err := r.ParseForm(); err != nil {
-			http.Error(w, "Bad request body", http.StatusBadRequest)
-			return
-		}
-
-		toUint := func(s string) (uint, error) {
-			if s == "" {
-				return 0, errors.New("empty")
+			if len(cfg.Instances) == 0 || cfg.Mode != "http" {
+				logrus.Fatalln(err)
 			}
-			num, err := strconv.ParseUint(s, 10, 64)
-			if err != nil {
-				return 0, err
-			}
-			return uint(num), nil
-		}
-
-		blockIndex, err := toUint(r.FormValue("block_index"))
-		if err != nil {
-			http.Error(w, "Invalid/empty block_index", http.StatusBadRequest)
-			return
-		}
-
-		blockBottom, err := toUint(r.FormValue("block_bottom"))
-		if err != nil {
-			http.Error(w, "Invalid/empty block_bottom", http.StatusBadRequest)
-			return
-		}
-
-		blockRight, err := toUint(r.FormValue("block_right"))
-		if err != nil {
-			http.Error(w, "Invalid/empty block_right", http.StatusBadRequest)
-			return
-		}
-
-		blockTop, err := toUint(r.FormValue("block_top"))
-		if err != nil {
-			http.Error(w, "Invalid/empty block_top", http.StatusBadRequest)
-			return
-		}
-
-		blockLeft, err := toUint(r.FormValue("block_left"))
-		if err != nil {
-			http.Error(w, "Invalid/empty block_left", http.StatusBadRequest)
-			return
-		}
-
-		canvasIndex, err := toUint(r.FormValue("canvas_index"))
-		if err != nil {
-			http.Error(w, "Invalid/empty canvas_index", http.StatusBadRequest)
-			return
+			// Others can still be used, and this one added again later
+			logrus.Errorln(err)
 		}
+	}
 
-		galleryIdentificationNumber, err := toUint(r.FormValue("gallery_identification_number"))
+	switch cfg.Mode {
+	case "mcp":
+		m := newMCPServer(instances.Default(), activity, cfg.IIIF.MaxArea)
+		notifications, unsubscribe := m.Notifications()
+		err := (&jsonrpc.Server{Handler: m.Handle}).ServeStream(os.Stdin, os.Stdout, notifications)
+		unsubscribe()
 		if err != nil {
-			http.Error(w, "Invalid/empty gallery_identification_number", http.StatusBadRequest)
-			return
+			logrus.Fatalln(err)
 		}
-
-		scp, err := client.SendCommandSync(
-			commands.PreviewWebtoonFromClient,
-			commands.DetailPreviewWebtoonFromClientReadPreviewBlock{
-				Operation:                   "ReadPreviewBlock",
-				BlockIndex:                  blockIndex,
-				BlockBottom:                 blockBottom,
-				BlockRight:                  blockRight,
-				BlockTop:                    blockTop,
-				BlockLeft:                   blockLeft,
-				CanvasIndex:                 canvasIndex,
-				GalleryIdentificationNumber: galleryIdentificationNumber,
-			},
-		)
+		return
+	case "stdio":
+		conn := instances.Default()
+		rpc := &jsonrpc.Server{Handler: rpcHandler(conn, activity)}
+		notifications, unsubscribe := rpcNotifications(conn)
+		err := rpc.ServeStream(os.Stdin, os.Stdout, notifications)
+		unsubscribe()
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-
-		if len(scp.Data) == 0 {
-			http.Error(w, "No image data", http.StatusInternalServerError)
-			return
+			logrus.Fatalln(err)
 		}
+		return
+	}
 
-		rgbData, err := base64.RawStdEncoding.DecodeString(string(scp.Data))
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+	mux := http.NewServeMux()
+	mux.Handle("/", instances) // Routes of the default instance
+	if cfg.endpointEnabled("instances") {
+		mux.Handle("/instances", instancesHandler(instances))
+		mux.Handle("/instances/", instancesHandler(instances))
+	}
+	if cfg.endpointEnabled("events") {
+		mux.Handle("/events", eventsHandler(activity))
+	}
+	if cfg.endpointEnabled("openapi") {
+		mux.HandleFunc("/openapi.json", openAPIHandler)
+	}
 
-		// Render preview as PNG
-		img := preview.Decode(rgbData, int(blockRight-blockLeft), int(blockBottom-blockTop))
-		w.Header().Set("content-type", "image/bmp")
-		w.WriteHeader(http.StatusOK)
-		bmp.Encode(w, img)
-	})
+	var handler http.Handler = mux
+	if len(cfg.Tokens) > 0 {
+		handler = (&authConfig{Tokens: cfg.Tokens}).Middleware(handler)
+	} else if !isLoopback(cfg.Listen) {
+		logrus.Warnln("serving on", cfg.Listen, "without any API tokens, anyone who can reach it can control CSP")
+	}
 
-	http.ListenAndServe(":8089", nil)
+	server := &http.Server{
+		Addr:              cfg.Listen,
+		Handler:           handler,
+		ReadHeaderTimeout: time.Duration(cfg.Timeouts.ReadHeader),
+		IdleTimeout:       time.Duration(cfg.Timeouts.Idle),
+	}
+	logrus.Infoln("serving on", cfg.Listen)
+	if cfg.TLS.Cert != "" {
+		err = server.ListenAndServeTLS(cfg.TLS.Cert, cfg.TLS.Key)
+	} else {
+		err = server.ListenAndServe()
+	}
+	logrus.Fatalln(err)
 }
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chocolatkey/clipremote"
+	"github.com/chocolatkey/clipremote/pkg/commands"
+	"github.com/chocolatkey/clipremote/pkg/jsonrpc"
+	"github.com/chocolatkey/clipremote/pkg/packets"
+	"github.com/chocolatkey/clipremote/pkg/preview"
+)
+
+// Model Context Protocol server, see https://modelcontextprotocol.io/specification
+const mcpProtocolVersion = "2024-11-05"
+
+const (
+	mcpStatusTool     = "connection_status"
+	mcpStatusURI      = "clipremote://status"
+	mcpCanvasURIStart = "clipremote://gallery/"
+)
+
+type mcpTool struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	InputSchema commands.Schema `json:"inputSchema"`
+}
+
+type mcpContent struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type mcpResource struct {
+	URI         string `json:"uri"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	MimeType    string `json:"mimeType"`
+}
+
+type mcpResourceContents struct {
+	URI      string `json:"uri"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Blob     string `json:"blob,omitempty"`
+}
+
+// Tool names can't contain slashes, so operations are joined with an underscore.
+func mcpToolName(spec commands.Spec) string {
+	return strings.ReplaceAll(spec.Name(), "/", "_")
+}
+
+func mcpTools() []mcpTool {
+	tools := []mcpTool{{
+		Name:        mcpStatusTool,
+		Description: "Get the status of the connection to Clip Studio Paint",
+		InputSchema: commands.Schema{"type": "object", "properties": map[string]interface{}{}},
+	}}
+	for _, spec := range commands.Registry {
+		if spec.Internal {
+			continue
+		}
+		schema := spec.RequestSchema()
+		if schema["type"] != "object" {
+			// Shape of the detail is unknown, so take any arguments as the detail
+			schema = commands.Schema{"type": "object"}
+		}
+		tools = append(tools, mcpTool{
+			Name:        mcpToolName(spec),
+			Description: spec.Description,
+			InputSchema: schema,
+		})
+	}
+	return tools
+}
+
+type mcpServer struct {
+	conn     *connection
+	activity *eventLog
+	maxArea  int // Largest canvas read as a resource, in pixels
+
+	initialized     chan struct{} // Closed once the client said it's initialized
+	initializedOnce sync.Once
+}
+
+func newMCPServer(conn *connection, activity *eventLog, maxArea int) *mcpServer {
+	return &mcpServer{
+		conn:        conn,
+		activity:    activity,
+		maxArea:     maxArea,
+		initialized: make(chan struct{}),
+	}
+}
+
+func (m *mcpServer) status() map[string]interface{} {
+	client := m.conn.Client()
+	return map[string]interface{}{
+		"alive":          client.Alive(),
+		"remote_address": client.RemoteAddr(),
+	}
+}
+
+func (m *mcpServer) callTool(name string, arguments json.RawMessage) (interface{}, error) {
+	text := func(v interface{}, isError bool) (interface{}, error) {
+		bin, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{
+			"content": []mcpContent{{Type: "text", Text: string(bin)}},
+			"isError": isError,
+		}, nil
+	}
+
+	if name == mcpStatusTool {
+		return text(m.status(), false)
+	}
+
+	var spec commands.Spec
+	var found bool
+	for _, s := range commands.Registry {
+		if !s.Internal && mcpToolName(s) == name {
+			spec, found = s, true
+			break
+		}
+	}
+	if !found {
+		return nil, &jsonrpc.Error{Code: jsonrpc.CodeInvalidParams, Message: "Unknown tool " + name}
+	}
+
+	if string(arguments) == "{}" || string(arguments) == "null" {
+		arguments = nil
+	}
+	detail, err := spec.DecodeDetail(arguments)
+	if err != nil {
+		return text(err.Error(), true)
+	}
+	if !m.conn.Client().Alive() {
+		return text("Not connected to Clip Studio Paint yet", true)
+	}
+
+	started := time.Now()
+	scp, err := m.conn.Client().SendCommandSync(spec.Command, detail)
+	m.activity.AddCommand("mcp", m.conn.name, spec.Command, detail, started, scp, err)
+	if err != nil {
+		return text(err.Error(), true)
+	}
+	return text(scp, scp.Type == packets.TypeServerResponseError)
+}
+
+func (m *mcpServer) listResources() (interface{}, error) {
+	resources := []mcpResource{{
+		URI:      mcpStatusURI,
+		Name:     "Connection status",
+		MimeType: "application/json",
+	}}
+	if m.conn.Client().Alive() {
+		gallery, err := m.conn.gallery.Get(m.conn.Client(), true)
+		if err != nil {
+			return nil, err
+		}
+		for i, size := range gallery.CanvasSizeArray {
+			resources = append(resources, mcpResource{
+				URI:         mcpCanvasURIStart + strconv.Itoa(i),
+				Name:        fmt.Sprintf("Canvas %d", i+1),
+				Description: fmt.Sprintf("Webtoon preview canvas, %d×%d pixels", size.CanvasWidth, size.CanvasHeight),
+				MimeType:    "image/png",
+			})
+		}
+	}
+	return map[string]interface{}{"resources": resources}, nil
+}
+
+func (m *mcpServer) readResource(uri string) (interface{}, error) {
+	if uri == mcpStatusURI {
+		bin, _ := json.Marshal(m.status())
+		return map[string]interface{}{
+			"contents": []mcpResourceContents{{URI: uri, MimeType: "application/json", Text: string(bin)}},
+		}, nil
+	}
+
+	canvasIndex, err := toUint(strings.TrimPrefix(uri, mcpCanvasURIStart))
+	if !strings.HasPrefix(uri, mcpCanvasURIStart) || err != nil {
+		return nil, &jsonrpc.Error{Code: jsonrpc.CodeInvalidParams, Message: "Unknown resource " + uri}
+	}
+	gallery, err := m.conn.gallery.Get(m.conn.Client(), false)
+	if err != nil {
+		return nil, err
+	}
+	if canvasIndex >= uint(len(gallery.CanvasSizeArray)) {
+		return nil, &jsonrpc.Error{Code: jsonrpc.CodeInvalidParams, Message: "Canvas not found"}
+	}
+	// The whole PNG is kept in memory and sent in one message
+	if size := gallery.CanvasSizeArray[canvasIndex]; int64(size.CanvasWidth)*int64(size.CanvasHeight) > int64(m.maxArea) {
+		return nil, &jsonrpc.Error{Code: jsonrpc.CodeInvalidParams, Message: fmt.Sprintf("Canvas is larger than %d pixels, read it in parts with the IIIF endpoint instead", m.maxArea)}
+	}
+
+	var buf bytes.Buffer
+	if err := preview.StreamCanvas(&buf, m.conn.Client(), gallery.GalleryIdentificationNumber, canvasIndex, gallery.CanvasSizeArray[canvasIndex], preview.DefaultFetchOptions); err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"contents": []mcpResourceContents{{
+			URI:      uri,
+			MimeType: "image/png",
+			Blob:     base64.StdEncoding.EncodeToString(buf.Bytes()),
+		}},
+	}, nil
+}
+
+func (m *mcpServer) Handle(_ context.Context, method string, params json.RawMessage) (interface{}, error) {
+	switch method {
+	case "initialize":
+		return map[string]interface{}{
+			"protocolVersion": mcpProtocolVersion,
+			"capabilities": map[string]interface{}{
+				"tools":     map[string]interface{}{},
+				"resources": map[string]interface{}{"listChanged": true},
+			},
+			"serverInfo": map[string]interface{}{
+				"name":    "clipremote",
+				"version": "1.0.0",
+			},
+		}, nil
+	case "ping":
+		return map[string]interface{}{}, nil
+	case "tools/list":
+		return map[string]interface{}{"tools": mcpTools()}, nil
+	case "tools/call":
+		var p struct {
+			Name      string          `json:"name"`
+			Arguments json.RawMessage `json:"arguments"`
+		}
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, &jsonrpc.Error{Code: jsonrpc.CodeInvalidParams, Message: "Invalid params"}
+		}
+		return m.callTool(p.Name, p.Arguments)
+	case "resources/list":
+		return m.listResources()
+	case "resources/read":
+		var p struct {
+			URI string `json:"uri"`
+		}
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, &jsonrpc.Error{Code: jsonrpc.CodeInvalidParams, Message: "Invalid params"}
+		}
+		return m.readResource(p.URI)
+	}
+	if method == "notifications/initialized" {
+		m.initializedOnce.Do(func() { close(m.initialized) })
+		return nil, nil
+	}
+	if strings.HasPrefix(method, "notifications/") {
+		return nil, nil // Nothing to do for the client's other notifications
+	}
+	return nil, &jsonrpc.Error{Code: jsonrpc.CodeMethodNotFound, Message: "Method not found"}
+}
+
+// Canvases may have changed whenever the server pushes something. Clients can't take
+// notifications before they're initialized, so changes until then are sent as one once they are.
+// Call the returned function to stop.
+func (m *mcpServer) Notifications() (<-chan jsonrpc.Notification, func()) {
+	events, unsubscribe := m.conn.Subscribe(64)
+	notifications := make(chan jsonrpc.Notification)
+	go func() {
+		defer close(notifications)
+		listChanged := jsonrpc.Notification{Method: "notifications/resources/list_changed"}
+		initialized := m.initialized
+		changed := false
+		for {
+			select {
+			case event, ok := <-events:
+				if !ok {
+					return
+				}
+				if event.Type != clipremote.EventPacket {
+					continue
+				}
+				if initialized != nil {
+					changed = true
+					continue
+				}
+				notifications <- listChanged
+			case <-initialized:
+				initialized = nil
+				if changed {
+					notifications <- listChanged
+				}
+			}
+		}
+	}()
+	return notifications, unsubscribe
+}
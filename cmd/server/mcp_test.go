@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/chocolatkey/clipremote"
+	"github.com/chocolatkey/clipremote/pkg/commands"
+	"github.com/chocolatkey/clipremote/pkg/jsonrpc"
+	"github.com/chocolatkey/clipremote/pkg/preview"
+)
+
+// Pass an event to the connection's subscribers, as relaying one from the client would.
+func push(conn *connection, event clipremote.Event) {
+	conn.subMu.Lock()
+	defer conn.subMu.Unlock()
+	for ch := range conn.subs {
+		ch <- event
+	}
+}
+
+func TestMCPNotificationsWaitForInitialized(t *testing.T) {
+	conn := newConnection("default", defaultConfig(), "", preview.NewBlockCache(1))
+	m := newMCPServer(conn, newEventLog(16), 100)
+	notifications, unsubscribe := m.Notifications()
+	defer unsubscribe()
+
+	expect := func(want bool) {
+		t.Helper()
+		select {
+		case n := <-notifications:
+			if !want {
+				t.Fatalf("got %s", n.Method)
+			}
+		case <-time.After(20 * time.Millisecond):
+			if want {
+				t.Fatal("got no notification")
+			}
+		}
+	}
+
+	push(conn, clipremote.Event{Type: clipremote.EventPacket})
+	push(conn, clipremote.Event{Type: clipremote.EventPacket})
+	m.Handle(context.Background(), "initialize", nil)
+	expect(false)
+	m.Handle(context.Background(), "notifications/initialized", nil)
+	expect(true) // Both changes at once
+	expect(false)
+	m.Handle(context.Background(), "notifications/initialized", nil) // Repeating it is harmless
+	push(conn, clipremote.Event{Type: clipremote.EventConnected})
+	expect(false)
+	push(conn, clipremote.Event{Type: clipremote.EventPacket})
+	expect(true)
+}
+
+func TestMCPReadResourceTooLarge(t *testing.T) {
+	conn := newConnection("default", defaultConfig(), "", preview.NewBlockCache(1))
+	conn.gallery.current = &commands.DetailPreviewWebtoonFromClientResponseUpdateGallery{
+		CanvasSizeArray: []commands.CanvasSize{{CanvasWidth: 100, CanvasHeight: 200}},
+	}
+	m := newMCPServer(conn, newEventLog(16), 100*199)
+	_, err := m.readResource(mcpCanvasURIStart + "0")
+	if rpcErr, ok := err.(*jsonrpc.Error); !ok || rpcErr.Code != jsonrpc.CodeInvalidParams {
+		t.Errorf("got %v, want invalid params", err)
+	}
+}
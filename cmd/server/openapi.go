@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/chocolatkey/clipremote/pkg/commands"
+)
+
+type object = map[string]interface{}
+
+func errorResponse(description string) object {
+	return object{
+		"description": description,
+		"content": object{
+			"text/plain": object{"schema": object{"type": "string"}},
+		},
+	}
+}
+
+// Schema of a ServerCommand as JSON, see its MarshalJSON, with the given detail schema.
+func serverCommandSchema(detail commands.Schema) object {
+	return object{
+		"type": "object",
+		"properties": object{
+			"command": object{"type": "string"},
+			"serial":  object{"type": "integer", "minimum": 0},
+			"type":    object{"type": "string", "enum": []string{"success", "error", "command"}},
+			"detail":  detail,
+			"data":    object{"type": "string", "description": "Raw data following the detail, if any"},
+		},
+		"required": []string{"command", "serial", "type"},
+	}
+}
+
+// Build the OpenAPI 3 document describing the typed command routes.
+func openAPIDocument() object {
+	paths := object{}
+	for _, spec := range commands.Registry {
+		if spec.Internal {
+			continue
+		}
+
+		operation := object{
+			"operationId": spec.Name(),
+			"summary":     spec.Description,
+			"tags":        []string{string(spec.Command)},
+			"responses": object{
+				"200": object{
+					"description": "Command succeeded",
+					"content": object{
+						"application/json": object{"schema": serverCommandSchema(spec.ResponseSchema())},
+					},
+				},
+				"422": object{
+					"description": "CSP responded with an error",
+					"content": object{
+						"application/json": object{"schema": serverCommandSchema(commands.Schema{})},
+					},
+				},
+				"400": errorResponse("Invalid detail"),
+				"502": errorResponse("Command could not be sent or got no response"),
+				"503": errorResponse("Not connected to CSP yet"),
+			},
+		}
+		if spec.Request != nil {
+			operation["requestBody"] = object{
+				"required": true,
+				"content": object{
+					"application/json": object{"schema": spec.RequestSchema()},
+				},
+			}
+		} else {
+			operation["requestBody"] = object{
+				"description": "Detail of the command. Its shape is not documented yet",
+				"content": object{
+					"application/json": object{"schema": object{}},
+				},
+			}
+		}
+
+		paths["/commands/"+spec.Name()] = object{"post": operation}
+	}
+
+	return object{
+		"openapi": "3.0.3",
+		"info": object{
+			"title":       "Clip Studio Paint Remote",
+			"description": "Send commands to Clip Studio Paint using its companion mode protocol",
+			"version":     "1.0.0",
+		},
+		"paths": paths,
+	}
+}
+
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+	w.Header().Set("access-control-allow-origin", "*")
+	json.NewEncoder(w).Encode(openAPIDocument())
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/chocolatkey/clipremote"
+	"github.com/chocolatkey/clipremote/pkg/packets"
+	"github.com/pkg/errors"
+)
+
+// Problem details (RFC 7807), the body of error responses when a command couldn't be sent.
+type problem struct {
+	Type     string          `json:"type"`
+	Title    string          `json:"title"`
+	Status   int             `json:"status"`
+	Detail   string          `json:"detail"`
+	Command  string          `json:"command,omitempty"`
+	Serial   *packets.Serial `json:"serial,omitempty"`
+	Response interface{}     `json:"response,omitempty"` // Detail of CSP's error response
+}
+
+// Problem for an error, with the fallback status if it isn't one of the known errors.
+func problemFor(err error, fallback int) problem {
+	p := problem{Type: "about:blank", Title: http.StatusText(fallback), Status: fallback, Detail: err.Error()}
+	var serverErr *clipremote.ServerError
+	switch {
+	case errors.Is(err, errForbidden):
+		p.Type, p.Title, p.Status = "urn:clipremote:forbidden", "Command not allowed", http.StatusForbidden
+	case errors.Is(err, clipremote.ErrNotAlive):
+		p.Type, p.Title, p.Status = "urn:clipremote:not-alive", "Not connected to CSP", http.StatusServiceUnavailable
+	case errors.Is(err, clipremote.ErrReset):
+		p.Type, p.Title, p.Status = "urn:clipremote:reset", "Connection to CSP was reset", http.StatusServiceUnavailable
+	case errors.Is(err, clipremote.ErrTimeout):
+		p.Type, p.Title, p.Status = "urn:clipremote:timeout", "CSP didn't respond in time", http.StatusGatewayTimeout
+	case errors.Is(err, clipremote.ErrAuthFailed):
+		p.Type, p.Title, p.Status = "urn:clipremote:auth-failed", "CSP refused to authenticate", http.StatusBadGateway
+	case errors.As(err, &serverErr):
+		p.Type, p.Title, p.Status = "urn:clipremote:refused", "CSP refused the command", http.StatusUnprocessableEntity
+	}
+	if errors.As(err, &serverErr) {
+		p.Command = string(serverErr.Command)
+		p.Serial = &serverErr.Serial
+		p.Response = serverErr.Detail
+	}
+	return p
+}
+
+// Status for an error, see problemFor.
+func errorStatus(err error, fallback int) int {
+	return problemFor(err, fallback).Status
+}
+
+// Respond with the problem for an error.
+func writeError(w http.ResponseWriter, err error, fallback int) {
+	p := problemFor(err, fallback)
+	w.Header().Set("content-type", "application/problem+json")
+	w.Header().Set("x-content-type-options", "nosniff")
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/chocolatkey/clipremote/pkg/commands"
+	"github.com/chocolatkey/clipremote/pkg/packets"
+)
+
+// Status to respond with for a command's result.
+func commandStatus(scp *packets.ServerCommand, err error) int {
+	switch {
+	case err != nil:
+		return errorStatus(err, http.StatusBadGateway)
+	case scp.Type == packets.TypeServerResponseError:
+		return http.StatusUnprocessableEntity // CSP understood the command but refused it
+	}
+	return http.StatusOK
+}
+
+// Typed routes for the commands in the registry: POST /commands/{command} or /commands/{command}/{operation}
+// with the detail as the JSON body.
+func restHandler(conn *connection, activity *eventLog) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("allow", http.MethodPost)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		spec, ok := commands.Lookup(strings.TrimPrefix(r.URL.Path, "/commands/"))
+		if !ok || spec.Internal {
+			http.Error(w, "Unknown command", http.StatusNotFound)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+		if err != nil {
+			http.Error(w, "Bad request body", http.StatusBadRequest)
+			return
+		}
+		detail, err := spec.DecodeDetail(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if !conn.Client().Alive() {
+			http.Error(w, "Not ready", http.StatusServiceUnavailable)
+			return
+		}
+
+		started := time.Now()
+		scp, err := guard(conn, r.Context()).SendCommandSync(spec.Command, detail)
+		activity.AddCommand("rest", conn.name, spec.Command, detail, started, scp, err)
+		if err != nil {
+			writeError(w, err, http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("content-type", "application/json; charset=utf-8")
+		w.WriteHeader(commandStatus(scp, err))
+		json.NewEncoder(w).Encode(scp)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/chocolatkey/clipremote"
+	"github.com/chocolatkey/clipremote/pkg/commands"
+	"github.com/chocolatkey/clipremote/pkg/jsonrpc"
+	"github.com/chocolatkey/clipremote/pkg/packets"
+	"github.com/pkg/errors"
+)
+
+// Server error codes used on top of the standard JSON-RPC ones.
+const (
+	rpcCodeCommandError = -32000 // CSP responded with an error
+	rpcCodeNotReady     = -32001 // Not connected to CSP yet
+	rpcCodeSendFailed   = -32002 // Command could not be sent or got no response
+	rpcCodeForbidden    = -32003 // Command is not allowed for the token used
+)
+
+// Params of the "send" method, which sends any command, known or not.
+type rpcSendParams struct {
+	Command string          `json:"command"`
+	Detail  json.RawMessage `json:"detail,omitempty"`
+}
+
+// Exposes every known command as a method named like its spec, with the detail as params.
+func rpcHandler(conn *connection, activity *eventLog) jsonrpc.Handler {
+	return func(ctx context.Context, method string, params json.RawMessage) (interface{}, error) {
+		var command commands.Command
+		var detail interface{}
+		if method == "send" {
+			var p rpcSendParams
+			if err := json.Unmarshal(params, &p); err != nil || p.Command == "" {
+				return nil, &jsonrpc.Error{Code: jsonrpc.CodeInvalidParams, Message: "Params must be an object with a command and optional detail"}
+			}
+			command = commands.Command(p.Command)
+			if len(p.Detail) > 2 {
+				if err := json.Unmarshal(p.Detail, &detail); err != nil {
+					return nil, &jsonrpc.Error{Code: jsonrpc.CodeInvalidParams, Message: "Invalid detail"}
+				}
+			}
+		} else {
+			spec, ok := commands.Lookup(method)
+			if !ok || spec.Internal {
+				return nil, &jsonrpc.Error{Code: jsonrpc.CodeMethodNotFound, Message: "Method not found"}
+			}
+			var err error
+			if detail, err = spec.DecodeDetail(params); err != nil {
+				return nil, &jsonrpc.Error{Code: jsonrpc.CodeInvalidParams, Message: err.Error()}
+			}
+			command = spec.Command
+		}
+
+		if !conn.Client().Alive() {
+			return nil, &jsonrpc.Error{Code: rpcCodeNotReady, Message: "Not ready"}
+		}
+
+		started := time.Now()
+		scp, err := guard(conn, ctx).SendCommandSync(command, detail)
+		activity.AddCommand("rpc", conn.name, command, detail, started, scp, err)
+		if errors.Is(err, errForbidden) {
+			return nil, &jsonrpc.Error{Code: rpcCodeForbidden, Message: err.Error()}
+		}
+		if errors.Is(err, clipremote.ErrNotAlive) {
+			return nil, &jsonrpc.Error{Code: rpcCodeNotReady, Message: "Not ready"}
+		}
+		if err != nil {
+			return nil, &jsonrpc.Error{Code: rpcCodeSendFailed, Message: err.Error(), Data: problemFor(err, http.StatusBadGateway)}
+		}
+		if scp.Type == packets.TypeServerResponseError {
+			return nil, &jsonrpc.Error{Code: rpcCodeCommandError, Message: "CSP responded with an error", Data: scp}
+		}
+		return scp, nil
+	}
+}
+
+// Client events as "event" notifications, for transports that can push them.
+// Call the returned function to stop.
+func rpcNotifications(source eventSource) (<-chan jsonrpc.Notification, func()) {
+	events, unsubscribe := source.Subscribe(64)
+	notifications := make(chan jsonrpc.Notification)
+	go func() {
+		defer close(notifications)
+		for event := range events {
+			notifications <- jsonrpc.Notification{Method: "event", Params: event}
+		}
+	}()
+	return notifications, unsubscribe
+}
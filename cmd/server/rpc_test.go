@@ -0,0 +1,22 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/chocolatkey/clipremote/pkg/preview"
+)
+
+func TestRPCNotificationsUnsubscribe(t *testing.T) {
+	conn := newConnection("default", defaultConfig(), "", preview.NewBlockCache(1))
+	notifications, unsubscribe := rpcNotifications(conn)
+	if len(conn.subs) != 1 {
+		t.Fatalf("got %d subscribers", len(conn.subs))
+	}
+	unsubscribe()
+	if _, ok := <-notifications; ok {
+		t.Error("notifications still open")
+	}
+	if len(conn.subs) != 0 {
+		t.Errorf("got %d subscribers left", len(conn.subs))
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chocolatkey/clipremote"
+	"github.com/chocolatkey/clipremote/pkg/commands"
+	"github.com/chocolatkey/clipremote/pkg/packets"
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+)
+
+// Only pages from the server itself or from an allowed origin can open sockets, since otherwise any
+// website open in the browser could control CSP through localhost. "*" allows every origin.
+func newUpgrader(allowedOrigins []string) *websocket.Upgrader {
+	return &websocket.Upgrader{
+		CheckOrigin: func(r *http.Request) bool {
+			origin := r.Header.Get("origin")
+			if origin == "" {
+				return true // Not a browser
+			}
+			for _, allowed := range allowedOrigins {
+				if allowed == "*" || strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+					return true
+				}
+			}
+			u, err := url.Parse(origin)
+			return err == nil && strings.EqualFold(u.Host, r.Host)
+		},
+	}
+}
+
+const (
+	wsOutgoingBuffer = 256              // Messages waiting to be written before the socket is closed for being too slow
+	wsWriteTimeout   = 10 * time.Second // For writing a single message
+)
+
+// Message sent by the WebSocket client to run a command.
+type wsRequest struct {
+	ID      json.RawMessage `json:"id"` // Chosen by the client, echoed back in the response
+	Command string          `json:"command"`
+	Detail  json.RawMessage `json:"detail,omitempty"`
+}
+
+// Message sent to the WebSocket client, either a response to one of its commands or an event.
+type wsResponse struct {
+	ID       json.RawMessage        `json:"id,omitempty"`
+	Response *packets.ServerCommand `json:"response,omitempty"`
+	Error    string                 `json:"error,omitempty"`
+	Event    *clipremote.Event      `json:"event,omitempty"`
+}
+
+// Accepts commands as JSON messages and sends back their responses as soon as they arrive,
+// in whatever order that is. Client events are pushed to every connected socket,
+// except packets of commands its token can't send.
+func wsHandler(conn *connection, activity *eventLog, upgrader *websocket.Upgrader) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return // Upgrade already replied with an error
+		}
+
+		// Responses are sent from the client's read loop, so writes happen on their own goroutine
+		// and a socket that can't keep up is closed rather than holding up everyone else's responses
+		out := make(chan wsResponse, wsOutgoingBuffer)
+		done := make(chan struct{})
+		var closeOnce sync.Once
+		stop := func() {
+			closeOnce.Do(func() {
+				close(done)
+				ws.Close()
+			})
+		}
+		defer stop()
+		send := func(msg wsResponse) {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			select {
+			case out <- msg:
+			default:
+				logrus.Warnln("websocket can't keep up, closing it")
+				stop()
+			}
+		}
+		go func() {
+			for {
+				select {
+				case <-done:
+					return
+				case msg := <-out:
+					ws.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+					if err := ws.WriteJSON(msg); err != nil {
+						logrus.Debugln("failed writing to websocket", err)
+						stop()
+						return
+					}
+				}
+			}
+		}()
+
+		token := tokenFrom(r.Context())
+		events, unsubscribe := conn.Subscribe(64)
+		defer unsubscribe()
+		go func() {
+			for event := range events {
+				event := event
+				if event.Packet != nil && !token.Allows(event.Packet.Command, event.Packet.Detail) {
+					continue // Same as for /events
+				}
+				send(wsResponse{Event: &event})
+			}
+		}()
+
+		for {
+			_, msg, err := ws.ReadMessage()
+			if err != nil {
+				return // Closed
+			}
+			var req wsRequest
+			if err := json.Unmarshal(msg, &req); err != nil {
+				send(wsResponse{Error: "Invalid message, must be a JSON object with a string command"})
+				continue
+			}
+			if req.Command == "" {
+				send(wsResponse{ID: req.ID, Error: "Missing command"})
+				continue
+			}
+
+			var detail interface{}
+			if len(req.Detail) > 2 {
+				if err := json.Unmarshal(req.Detail, &detail); err != nil {
+					send(wsResponse{ID: req.ID, Error: "Invalid detail"})
+					continue
+				}
+			}
+
+			id := req.ID
+			started := time.Now()
+			guard(conn, r.Context()).SendCommand(commands.Command(req.Command), detail, func(scp *packets.ServerCommand, err error) {
+				activity.AddCommand("ws", conn.name, commands.Command(req.Command), detail, started, scp, err)
+				if err != nil {
+					send(wsResponse{ID: id, Response: scp, Error: err.Error()})
+					return
+				}
+				send(wsResponse{ID: id, Response: scp})
+			})
+		}
+	}
+}
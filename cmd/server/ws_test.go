@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/chocolatkey/clipremote/pkg/preview"
+	"github.com/gorilla/websocket"
+)
+
+func TestUpgraderCheckOrigin(t *testing.T) {
+	tests := []struct {
+		origin  string
+		allowed []string
+		want    bool
+	}{
+		{"", nil, true},
+		{"http://127.0.0.1:8089", nil, true},
+		{"http://evil.example", nil, false},
+		{"http://127.0.0.1:8090", nil, false},
+		{"https://tools.example", []string{"https://tools.example/"}, true},
+		{"https://TOOLS.example", []string{"https://tools.example"}, true},
+		{"http://evil.example", []string{"https://tools.example"}, false},
+		{"http://evil.example", []string{"*"}, true},
+		{"%%", nil, false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "http://127.0.0.1:8089/ws", nil)
+		if tt.origin != "" {
+			r.Header.Set("origin", tt.origin)
+		}
+		if got := newUpgrader(tt.allowed).CheckOrigin(r); got != tt.want {
+			t.Errorf("origin %q with %v: got %v, want %v", tt.origin, tt.allowed, got, tt.want)
+		}
+	}
+}
+
+func TestWSInvalidMessagesKeepSocketOpen(t *testing.T) {
+	conn := newConnection("default", defaultConfig(), "", preview.NewBlockCache(1))
+	srv := httptest.NewServer(wsHandler(conn, newEventLog(16), newUpgrader(nil)))
+	defer srv.Close()
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	for _, msg := range []string{`{"command":5}`, `not json`, `[]`, `{"id":1}`} {
+		if err := ws.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+		var res wsResponse
+		if err := ws.ReadJSON(&res); err != nil {
+			t.Fatalf("%s: socket closed: %v", msg, err)
+		}
+		if res.Error == "" {
+			t.Errorf("%s: no error in response", msg)
+		}
+	}
+}
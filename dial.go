@@ -0,0 +1,194 @@
+package clipremote
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/chocolatkey/clipremote/pkg/packets"
+	cmap "github.com/orcaman/concurrent-map/v2"
+	"github.com/pkg/errors"
+)
+
+// How long to wait for an address to connect before also dialing the next one, as in RFC 8305.
+const DefaultDialStagger = 250 * time.Millisecond
+
+// How long dialing an address gets, unless changed with WithDialTimeout.
+const DefaultDialTimeout = 10 * time.Second
+
+// How long a connection gets to authenticate when it isn't limited by WithRequestTimeout.
+const defaultAuthTimeout = 30 * time.Second
+
+// DialAttempt is how connecting to one of the addresses went, for diagnostics.
+type DialAttempt struct {
+	Address     string        // Host and port dialed
+	Latency     time.Duration // Until connected or failed. Zero if never dialed
+	AuthLatency time.Duration // Until authenticated or refused, if authentication was tried
+	Err         error         // Why the address wasn't used, if it was dialed and isn't the winner
+	Won         bool          // The client uses this connection
+}
+
+type dialResult struct {
+	index   int
+	conn    net.Conn
+	err     error
+	latency time.Duration
+}
+
+// Dial the hosts in order, starting the next one after the stagger delay or as soon as an
+// earlier one fails. Every host gets exactly one result, so hosts not dialed yet when the
+// context is done get its error.
+func (o *clientOptions) dialAll(ctx context.Context, hosts []string) <-chan dialResult {
+	results := make(chan dialResult, len(hosts))
+	failed := make(chan struct{}, len(hosts))
+	go func() {
+		for i, host := range hosts {
+			if i > 0 {
+				timer := time.NewTimer(o.dialStagger)
+				select {
+				case <-ctx.Done():
+					timer.Stop()
+					for j := i; j < len(hosts); j++ {
+						results <- dialResult{index: j, err: ctx.Err()}
+					}
+					return
+				case <-failed:
+					timer.Stop()
+				case <-timer.C:
+				}
+			}
+			go func(i int, host string) {
+				o.logger.Debugln("dialing", host)
+				started := time.Now()
+				dialCtx, cancel := context.WithTimeout(ctx, o.dialTimeout)
+				conn, err := o.open(dialCtx, host)
+				cancel()
+				if err != nil {
+					failed <- struct{}{}
+				}
+				results <- dialResult{index: i, conn: conn, err: err, latency: time.Since(started)}
+			}(i, host)
+		}
+	}()
+	return results
+}
+
+// Dial all the addresses and keep the first connection to authenticate, trying them in the
+// order they connected, or the first to connect if there's no password. Connections are
+// authenticated one at a time, since authenticating changes the password and two connections
+// authenticating at once would leave one of them with a password CSP doesn't know anymore.
+func dial(ctx context.Context, ipAddresses []string, port uint16, generation string, o clientOptions) (*Client, error) {
+	if len(ipAddresses) == 0 {
+		return nil, errors.New("no addresses to connect to")
+	}
+	hosts := make([]string, len(ipAddresses))
+	attempts := make([]DialAttempt, len(ipAddresses))
+	for i, address := range ipAddresses {
+		hosts[i] = net.JoinHostPort(address, strconv.Itoa(int(port)))
+		attempts[i].Address = hosts[i]
+	}
+
+	dialCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := o.dialAll(dialCtx, hosts)
+
+	var client *Client
+	var lastErr error
+	for remaining := len(hosts); remaining > 0; remaining-- {
+		result := <-results
+		attempt := &attempts[result.index]
+		attempt.Latency = result.latency
+		if result.err != nil {
+			o.logger.Debugln("failed dialing", hosts[result.index], result.err)
+			attempt.Err = result.err
+			lastErr = errors.Wrap(result.err, "failed dialing "+hosts[result.index])
+			continue
+		}
+
+		candidate := newClient(result.conn, o.transportTo(hosts[result.index]), ipAddresses, port, generation, o)
+		if o.authenticate {
+			started := time.Now()
+			err := candidate.authenticateWithin(o.password, o.authTimeout())
+			attempt.AuthLatency = time.Since(started)
+			if err != nil {
+				o.logger.Debugln("failed authenticating over", hosts[result.index], err)
+				candidate.Close()
+				attempt.Err = err
+				lastErr = err
+				continue
+			}
+		}
+		attempt.Won = true
+		client = candidate
+
+		// The others aren't needed anymore, and won't be waited for
+		for i := range attempts {
+			if !attempts[i].Won && attempts[i].Err == nil {
+				attempts[i].Err = errors.New("another address won")
+			}
+		}
+		go func(remaining int) {
+			for ; remaining > 0; remaining-- {
+				if result := <-results; result.conn != nil {
+					result.conn.Close()
+				}
+			}
+		}(remaining - 1)
+		break
+	}
+	if client == nil {
+		return nil, lastErr
+	}
+	client.dialAttempts = attempts
+	client.log.Infoln("connected to " + client.RemoteAddr())
+	return client, nil
+}
+
+func newClient(conn Transport, open TransportFactory, ipAddresses []string, port uint16, generation string, o clientOptions) *Client {
+	client := &Client{
+		open:        open,
+		ipAddresses: ipAddresses,
+		port:        port,
+		generation:  generation,
+		timeout:     time.NewTimer(o.heartbeatInterval),
+		callbacks:   cmap.NewStringer[packets.Serial, packets.ClientCommandCallback](),
+		opts:        o,
+		log:         o.logger,
+	}
+	client.conn.Store(&conn)
+	client.heartbeatInterval.Store(int64(o.heartbeatInterval))
+	client.invoke = chainInterceptors(o.interceptors, client.send)
+	client.Reset()
+	client.emit(EventConnected, client.RemoteAddr(), nil)
+	go client.loop()
+	return client
+}
+
+// Authenticate, giving up after the timeout.
+func (c *Client) authenticateWithin(password string, timeout time.Duration) error {
+	done := make(chan error, 1)
+	c.Authenticate(func(scp *packets.ServerCommand, err error) {
+		done <- err
+	}, password)
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		return errors.Wrap(ErrTimeout, "waiting for authentication")
+	}
+}
+
+// Connect to the session's CSP instance and authenticate. All its addresses are dialed,
+// staggered like with New, and the first connection to authenticate is kept.
+// Save the client's session after, since the password changed.
+func ConnectSession(ctx context.Context, session *Session, opts ...ClientOption) (*Client, error) {
+	return New(ctx, session.IPAddresses, session.Port, session.Generation, append(opts, WithPassword(session.Password))...)
+}
+
+// How connecting to each of the addresses went, in the order they were given.
+func (c *Client) DialAttempts() []DialAttempt {
+	return append([]DialAttempt(nil), c.dialAttempts...)
+}
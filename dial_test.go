@@ -0,0 +1,97 @@
+package clipremote
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/chocolatkey/clipremote/pkg/commands"
+	"github.com/chocolatkey/clipremote/pkg/packets"
+	"github.com/pkg/errors"
+)
+
+func TestDial(t *testing.T) {
+	csp := newFakeCSP(t, nil)
+	refusing := newFakeCSP(t, func(command commands.Command) packets.PacketType {
+		return packets.TypeServerResponseError
+	})
+	silent := newFakeCSP(t, func(command commands.Command) packets.PacketType {
+		return 0
+	})
+	hung := make(chan struct{})
+	defer close(hung)
+	var dialer net.Dialer
+	dial := func(ctx context.Context, network, address string) (net.Conn, error) {
+		host, _, _ := net.SplitHostPort(address)
+		switch host {
+		case "csp":
+			return dialer.DialContext(ctx, network, csp.ln.Addr().String())
+		case "refusing":
+			return dialer.DialContext(ctx, network, refusing.ln.Addr().String())
+		case "silent":
+			return dialer.DialContext(ctx, network, silent.ln.Addr().String())
+		case "hung": // Ignores the context
+			<-hung
+		}
+		return nil, errors.New("unreachable")
+	}
+
+	tests := []struct {
+		name      string
+		addresses []string
+		password  bool
+		won       int // Index of the winner, -1 if none
+		errs      []error
+	}{
+		{"first connects", []string{"csp"}, false, 0, []error{nil}},
+		{"first fails", []string{"unreachable", "csp"}, true, 1, nil},
+		{"first refuses", []string{"refusing", "csp"}, true, 1, []error{ErrAuthFailed, nil}},
+		{"first isn't CSP", []string{"silent", "csp"}, true, 1, []error{ErrTimeout, nil}},
+		{"first hangs", []string{"hung", "csp"}, true, 1, nil},
+		{"none work", []string{"unreachable", "refusing"}, true, -1, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := []ClientOption{WithDialFunc(dial), WithDialStagger(20 * time.Millisecond), WithRequestTimeout(200 * time.Millisecond)}
+			if tt.password {
+				opts = append(opts, WithPassword("password"))
+			}
+			done := make(chan struct{})
+			var client *Client
+			var err error
+			go func() {
+				defer close(done)
+				client, err = New(context.Background(), tt.addresses, 2000, "gen", opts...)
+			}()
+			select {
+			case <-done:
+			case <-time.After(2 * time.Second):
+				t.Fatal("still dialing")
+			}
+			if tt.won < 0 {
+				if err == nil {
+					client.Close()
+					t.Fatal("connected")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+			attempts := client.DialAttempts()
+			for i, attempt := range attempts {
+				if attempt.Won != (i == tt.won) {
+					t.Errorf("%s: won %v", attempt.Address, attempt.Won)
+				}
+				if i < len(tt.errs) && !errors.Is(attempt.Err, tt.errs[i]) {
+					t.Errorf("%s: got %v, want %v", attempt.Address, attempt.Err, tt.errs[i])
+				}
+			}
+			if tt.password && !client.Alive() {
+				t.Error("not authenticated")
+			}
+		})
+	}
+}
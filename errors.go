@@ -0,0 +1,36 @@
+package clipremote
+
+import (
+	"github.com/chocolatkey/clipremote/pkg/packets"
+	"github.com/pkg/errors"
+)
+
+// ServerError is an error response from CSP. See packets.ServerCommand.Err.
+type ServerError = packets.ServerError
+
+// Errors commands can fail with, for use with errors.Is.
+var (
+	ErrTimeout    = errors.New("timed out")
+	ErrNotAlive   = errors.New("client is not alive")
+	ErrAuthFailed = errors.New("authentication failed")
+	ErrReset      = errors.New("reset") // Either side reset the connection, see ErrClientReset and ErrServerReset
+
+	ErrClientReset error = resetError("client-side reset")
+	ErrServerReset error = resetError("server-side reset")
+)
+
+// Commands waiting for a response when the connection was reset fail with this.
+type resetError string
+
+func (e resetError) Error() string {
+	return string(e)
+}
+
+func (e resetError) Is(target error) bool {
+	return target == ErrReset
+}
+
+// Error for an error response, with a more specific reason.
+func serverError(scp *packets.ServerCommand, reason error) error {
+	return &ServerError{Command: scp.Command, Serial: scp.Serial, Detail: scp.Detail, Err: reason}
+}
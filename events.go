@@ -0,0 +1,75 @@
+package clipremote
+
+import (
+	"sync"
+	"time"
+
+	"github.com/chocolatkey/clipremote/pkg/packets"
+)
+
+type EventType string
+
+const (
+	EventConnected       EventType = "connected"        // Connection to the server was opened
+	EventAuthenticated   EventType = "authenticated"    // (Re)authentication succeeded
+	EventHeartbeatFailed EventType = "heartbeat_failed" // Heartbeat got no or an error response
+	EventReconnected     EventType = "reconnected"      // Connection was closed by the server and reopened
+	EventDisconnected    EventType = "disconnected"     // Connection was closed for good
+	EventReset           EventType = "reset"            // Pending commands were dropped, see Message for which side reset
+	EventPacket          EventType = "packet"           // Packet sent by the server on its own, not as a response
+)
+
+// Event is something that happened to the client's connection, or a packet pushed by the server.
+type Event struct {
+	Type    EventType              `json:"type"`
+	Time    time.Time              `json:"time"`
+	Message string                 `json:"message,omitempty"`
+	Packet  *packets.ServerCommand `json:"packet,omitempty"`
+}
+
+type subscribers struct {
+	mu   sync.Mutex
+	next int
+	subs map[int]chan Event
+}
+
+// Subscribe to the client's events. Events are dropped for subscribers whose buffer is full,
+// so a slow subscriber can't hold up the connection. Call the returned function to unsubscribe.
+func (c *Client) Subscribe(buffer int) (<-chan Event, func()) {
+	c.subscribers.mu.Lock()
+	defer c.subscribers.mu.Unlock()
+	if c.subscribers.subs == nil {
+		c.subscribers.subs = make(map[int]chan Event)
+	}
+	id := c.subscribers.next
+	c.subscribers.next++
+	ch := make(chan Event, buffer)
+	c.subscribers.subs[id] = ch
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			c.subscribers.mu.Lock()
+			defer c.subscribers.mu.Unlock()
+			delete(c.subscribers.subs, id)
+			close(ch)
+		})
+	}
+}
+
+func (c *Client) emit(typ EventType, message string, packet *packets.ServerCommand) {
+	event := Event{
+		Type:    typ,
+		Time:    time.Now(),
+		Message: message,
+		Packet:  packet,
+	}
+	c.subscribers.mu.Lock()
+	defer c.subscribers.mu.Unlock()
+	for _, ch := range c.subscribers.subs {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package clipremote
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/chocolatkey/clipremote/pkg/commands"
+	"github.com/chocolatkey/clipremote/pkg/packets"
+)
+
+// Fake CSP server. Every command is answered with what answer returns for it, or success if
+// answer is nil. Commands answered with 0 never get a response.
+type fakeCSP struct {
+	ln     net.Listener
+	answer func(command commands.Command) packets.PacketType
+
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+func newFakeCSP(t *testing.T, answer func(command commands.Command) packets.PacketType) *fakeCSP {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeCSP{ln: ln, answer: answer}
+	t.Cleanup(f.close)
+	go f.serve()
+	return f
+}
+
+func (f *fakeCSP) serve() {
+	for {
+		conn, err := f.ln.Accept()
+		if err != nil {
+			return
+		}
+		f.mu.Lock()
+		f.conns = append(f.conns, conn)
+		f.mu.Unlock()
+		go f.handle(conn)
+	}
+}
+
+func (f *fakeCSP) handle(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	for {
+		data, err := r.ReadBytes(0)
+		if err != nil {
+			return
+		}
+		frags := bytes.Split(data[2:len(data)-2], []byte{0x1e, '$'})
+		command := commands.Command(frags[1][len("command="):])
+		serial := frags[2][len("serial="):]
+		typ := packets.TypeServerResponseSuccess
+		if f.answer != nil {
+			typ = f.answer(command)
+		}
+		if typ == 0 {
+			continue
+		}
+		fmt.Fprintf(conn, "%c$tcp_remote_command_protocol_version=1.0\x1e$command=%s\x1e$serial=%s\x1e$detail={\"ok\":true,\"pad\":\"xxxxxxxxxxxxxxxxxxxx\"}\x1e\x00", typ, command, serial)
+	}
+}
+
+// End the connections made so far, like CSP going away.
+func (f *fakeCSP) drop() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, conn := range f.conns {
+		conn.(*net.TCPConn).CloseWrite() // Closing with commands left unread would reset it instead
+	}
+}
+
+func (f *fakeCSP) close() {
+	f.ln.Close()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, conn := range f.conns {
+		conn.Close()
+	}
+}
+
+func (f *fakeCSP) port() uint16 {
+	return uint16(f.ln.Addr().(*net.TCPAddr).Port)
+}
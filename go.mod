@@ -3,10 +3,19 @@ module github.com/chocolatkey/clipremote
 go 1.18
 
 require (
-	github.com/orcaman/concurrent-map v1.0.0 // indirect
-	github.com/orcaman/concurrent-map/v2 v2.0.1 // indirect
-	github.com/pkg/errors v0.9.1 // indirect
-	github.com/sirupsen/logrus v1.9.0 // indirect
-	golang.org/x/image v0.3.0 // indirect
+	github.com/BurntSushi/toml v1.2.1
+	github.com/chzyer/readline v1.5.1
+	github.com/gorilla/websocket v1.5.0
+	github.com/makiuchi-d/gozxing v0.1.1
+	github.com/orcaman/concurrent-map/v2 v2.0.1
+	github.com/pkg/errors v0.9.1
+	github.com/sirupsen/logrus v1.9.0
+	golang.org/x/image v0.3.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
 	golang.org/x/sys v0.0.0-20220722155257-8c9f86f7a55f // indirect
+	golang.org/x/text v0.6.0 // indirect
+	golang.org/x/xerrors v0.0.0-20200804184101-5ec99f83aff1 // indirect
 )
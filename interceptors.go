@@ -0,0 +1,74 @@
+package clipremote
+
+import (
+	"time"
+
+	"github.com/chocolatkey/clipremote/pkg/packets"
+	"github.com/sirupsen/logrus"
+)
+
+// Invoker sends a command on, calling its callback with the response. Returns the serial the
+// command was written with, or NotSent.
+type Invoker func(cmd *packets.ClientCommand) packets.Serial
+
+// Interceptor wraps sending every command, including heartbeats and authentication. It calls
+// next to send the command on and returns what it returned, and wraps cmd.Callback to see the
+// response. It may also change the command, answer it without calling next and return NotSent,
+// or send a copy of it again later to retry. The serial is only set once the command is written.
+type Interceptor func(cmd *packets.ClientCommand, next Invoker) packets.Serial
+
+// Chain the interceptors in front of the invoker, the first one being the outermost.
+func chainInterceptors(interceptors []Interceptor, invoke Invoker) Invoker {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], invoke
+		invoke = func(cmd *packets.ClientCommand) packets.Serial {
+			return interceptor(cmd, next)
+		}
+	}
+	return invoke
+}
+
+// Call done with how long the command took and why it failed, error responses included,
+// before its callback.
+func onResponse(cmd *packets.ClientCommand, done func(took time.Duration, err error)) {
+	started := time.Now()
+	callback := cmd.Callback
+	cmd.Callback = func(scp *packets.ServerCommand, err error) {
+		failure := err
+		if failure == nil && scp != nil {
+			failure = scp.Err()
+		}
+		done(time.Since(started), failure)
+		callback(scp, err)
+	}
+}
+
+// LoggingInterceptor logs every command once it's answered, failures as warnings.
+func LoggingInterceptor(logger logrus.FieldLogger) Interceptor {
+	return func(cmd *packets.ClientCommand, next Invoker) packets.Serial {
+		onResponse(cmd, func(took time.Duration, err error) {
+			log := logger.WithFields(logrus.Fields{
+				"command": cmd.Command,
+				"serial":  cmd.Serial,
+				"took":    took,
+			})
+			if err != nil {
+				log.WithError(err).Warnln("command failed")
+			} else {
+				log.Debugln("command succeeded")
+			}
+		})
+		return next(cmd)
+	}
+}
+
+// TimingInterceptor calls observe with how long every command took to be answered, and why it
+// failed if it did. Use it for metrics.
+func TimingInterceptor(observe func(cmd packets.ClientCommand, took time.Duration, err error)) Interceptor {
+	return func(cmd *packets.ClientCommand, next Invoker) packets.Serial {
+		onResponse(cmd, func(took time.Duration, err error) {
+			observe(*cmd, took, err)
+		})
+		return next(cmd)
+	}
+}
@@ -0,0 +1,72 @@
+package clipremote
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/chocolatkey/clipremote/pkg/commands"
+	"github.com/chocolatkey/clipremote/pkg/packets"
+)
+
+func TestChainInterceptorsOrder(t *testing.T) {
+	var order []string
+	named := func(name string) Interceptor {
+		return func(cmd *packets.ClientCommand, next Invoker) packets.Serial {
+			order = append(order, name)
+			return next(cmd)
+		}
+	}
+	invoke := chainInterceptors([]Interceptor{named("outer"), named("inner")}, func(cmd *packets.ClientCommand) packets.Serial {
+		order = append(order, "send")
+		return 7
+	})
+	if serial := invoke(&packets.ClientCommand{}); serial != 7 {
+		t.Errorf("got serial %d, want what the invoker returned", serial)
+	}
+	if got := len(order); got != 3 || order[0] != "outer" || order[1] != "inner" || order[2] != "send" {
+		t.Errorf("got order %v", order)
+	}
+}
+
+func TestTimingInterceptor(t *testing.T) {
+	failed := errors.New("failed")
+	tests := []struct {
+		name    string
+		scp     *packets.ServerCommand
+		err     error
+		wantErr bool
+	}{
+		{"success", &packets.ServerCommand{Type: packets.TypeServerResponseSuccess}, nil, false},
+		{"error response", &packets.ServerCommand{Type: packets.TypeServerResponseError}, nil, true},
+		{"not sent", nil, failed, true},
+	}
+	for _, tt := range tests {
+		var observed error
+		calls := 0
+		interceptor := TimingInterceptor(func(cmd packets.ClientCommand, took time.Duration, err error) {
+			calls++
+			observed = err
+			if cmd.Command != commands.GetServerSelectedTabKind {
+				t.Errorf("%s: observed %s", tt.name, cmd.Command)
+			}
+		})
+		answered := false
+		cmd := &packets.ClientCommand{Command: commands.GetServerSelectedTabKind, Callback: func(scp *packets.ServerCommand, err error) {
+			answered = true
+			if calls != 1 {
+				t.Errorf("%s: callback ran before the observer", tt.name)
+			}
+		}}
+		interceptor(cmd, func(cmd *packets.ClientCommand) packets.Serial {
+			cmd.Callback(tt.scp, tt.err)
+			return 1
+		})
+		if !answered {
+			t.Errorf("%s: original callback not called", tt.name)
+		}
+		if (observed != nil) != tt.wantErr {
+			t.Errorf("%s: observed error %v", tt.name, observed)
+		}
+	}
+}
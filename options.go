@@ -0,0 +1,132 @@
+package clipremote
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/chocolatkey/clipremote/pkg/protocol"
+	"github.com/sirupsen/logrus"
+)
+
+// DialFunc opens a connection to CSP, like net.Dialer.DialContext. Use it to go through a
+// SOCKS5 proxy or an SSH tunnel.
+type DialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+// ClientOption changes how New makes a client.
+type ClientOption func(*clientOptions)
+
+type clientOptions struct {
+	dial              DialFunc
+	logger            logrus.FieldLogger
+	heartbeatInterval time.Duration
+	requestTimeout    time.Duration
+	wrapConn          func(net.Conn) net.Conn
+	dialStagger       time.Duration
+	dialTimeout       time.Duration
+	password          string
+	authenticate      bool
+	interceptors      []Interceptor
+}
+
+func defaultClientOptions() clientOptions {
+	return clientOptions{
+		dial:              (&net.Dialer{}).DialContext,
+		logger:            logrus.StandardLogger(),
+		heartbeatInterval: protocol.HeartbeatTimeout,
+		dialStagger:       DefaultDialStagger,
+		dialTimeout:       DefaultDialTimeout,
+	}
+}
+
+// Dial with the dialer, for its timeout or local address for example.
+func WithDialer(dialer *net.Dialer) ClientOption {
+	return WithDialFunc(dialer.DialContext)
+}
+
+// Dial with the function instead of net.Dial. It's also used when reconnecting.
+func WithDialFunc(dial DialFunc) ClientOption {
+	return func(o *clientOptions) {
+		o.dial = dial
+	}
+}
+
+// Log to the logger instead of the standard logrus one.
+func WithLogger(logger logrus.FieldLogger) ClientOption {
+	return func(o *clientOptions) {
+		o.logger = logger
+	}
+}
+
+// How long the connection may be idle before a heartbeat is sent, see SetHeartbeatInterval.
+func WithHeartbeatInterval(interval time.Duration) ClientOption {
+	return func(o *clientOptions) {
+		o.heartbeatInterval = interval
+	}
+}
+
+// How long SendCommandSync waits for a response before failing with ErrTimeout.
+// Zero, the default, waits as long as it takes.
+func WithRequestTimeout(timeout time.Duration) ClientOption {
+	return func(o *clientOptions) {
+		o.requestTimeout = timeout
+	}
+}
+
+// Wrap every connection opened, for recording, throttling or injecting faults.
+func WithConnWrapper(wrap func(net.Conn) net.Conn) ClientOption {
+	return func(o *clientOptions) {
+		o.wrapConn = wrap
+	}
+}
+
+// How long an address gets to connect before the next one is dialed too.
+func WithDialStagger(stagger time.Duration) ClientOption {
+	return func(o *clientOptions) {
+		o.dialStagger = stagger
+	}
+}
+
+// How long connecting to an address, or reconnecting, may take.
+func WithDialTimeout(timeout time.Duration) ClientOption {
+	return func(o *clientOptions) {
+		o.dialTimeout = timeout
+	}
+}
+
+// Authenticate with the password while connecting, so only an address where CSP accepts it
+// is kept. Save the client's session after, since the password changed.
+func WithPassword(password string) ClientOption {
+	return func(o *clientOptions) {
+		o.password = password
+		o.authenticate = true
+	}
+}
+
+// Run every command through the interceptors, the first one being the outermost.
+// Can be given more than once to add more.
+func WithInterceptors(interceptors ...Interceptor) ClientOption {
+	return func(o *clientOptions) {
+		o.interceptors = append(o.interceptors, interceptors...)
+	}
+}
+
+// How long a connection gets to authenticate.
+func (o *clientOptions) authTimeout() time.Duration {
+	if o.requestTimeout > 0 {
+		return o.requestTimeout
+	}
+	return defaultAuthTimeout
+}
+
+// Open a connection with the options' dial function, wrapped if there's a wrapper.
+func (o *clientOptions) open(ctx context.Context, address string) (net.Conn, error) {
+	conn, err := o.dial(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	if o.wrapConn != nil {
+		conn = o.wrapConn(conn)
+	}
+	return conn, nil
+}
@@ -0,0 +1,151 @@
+// Code generated by gencommands from commands.yaml. DO NOT EDIT.
+
+package commands
+
+const (
+	TellHeartbeat            Command = "TellHeartbeat"            // Send heartbeat to server for keepalive
+	Authenticate             Command = "Authenticate"             // Authenticate with the server
+	GetModifyKeyString       Command = "GetModifyKeyString"       // Get/Set pressed modifier keys (Ctrl, Alt, Shift)
+	GetServerSelectedTabKind Command = "GetServerSelectedTabKind" // Get selected tab from server
+	SetServerSelectedTabKind Command = "SetServerSelectedTabKind" // When tab in remote control app is selected
+	PreviewWebtoonFromClient Command = "PreviewWebtoonFromClient" // Preview webtoon from remote control app
+)
+
+// CommandTellHeartbeat //
+
+type DetailTellHeartbeatRequest struct {
+	IdleTimerResetRequested bool
+}
+
+// CommandGetModifyKeyString //
+
+type DetailGetModifyKeyStringRequest struct {
+	AltPushed   bool
+	CtrlPushed  bool
+	ShiftPushed bool
+}
+
+type DetailGetModifyKeyStringResponse struct {
+	AltDescription   string
+	CtrlDescription  string
+	ShiftDescription string
+	SystemKind       string // E.g. "Windows"
+}
+
+// CommandGetServerSelectedTabKind //
+
+type DetailGetServerSelectedTabKindResponse struct {
+	ServerSelectedTabKind string // Typically "Invalid"
+}
+
+// CommandPreviewWebtoonFromClient //
+
+type DetailPreviewWebtoonFromClientRequestUpdateGallery struct {
+	MaxLength uint
+	Operation string // "UpdateGallery"
+}
+
+/*
+Example:
+
+	{
+		"Operation": "UpdateGallery",
+		"GalleryIdentificationNumber": 1,
+		"CanvasSizeArray": [
+			{ "CanvasHeight": 22153, "CanvasWidth": 690 },
+			{ "CanvasHeight": 10406, "CanvasWidth": 345 },
+			{ "CanvasHeight": 10561, "CanvasWidth": 345 },
+			{ "CanvasHeight": 10612, "CanvasWidth": 345 },
+			{ "CanvasHeight": 10140, "CanvasWidth": 345 },
+			{ "CanvasHeight": 10617, "CanvasWidth": 345 },
+			{ "CanvasHeight": 4660, "CanvasWidth": 345 }
+		],
+		"CanvasCount": 7
+	}
+*/
+type DetailPreviewWebtoonFromClientResponseUpdateGallery struct {
+	Operation                   string // "UpdateGallery"
+	GalleryIdentificationNumber uint
+	CanvasSizeArray             []CanvasSize
+	CanvasCount                 uint
+}
+
+/*
+Example:
+
+	{
+		"BlockIndex": 0,
+		"BlockBottom": 1024,
+		"BlockRight": 690,
+		"BlockTop": 0,
+		"BlockLeft": 0,
+		"CanvasIndex": 0,
+		"GalleryIdentificationNumber": 1,
+		"Operation": "ReadPreviewBlock"
+	}
+*/
+type DetailPreviewWebtoonFromClientReadPreviewBlock struct {
+	Operation                   string // "ReadPreviewBlock"
+	BlockIndex                  uint
+	BlockBottom                 uint
+	BlockRight                  uint
+	BlockTop                    uint
+	BlockLeft                   uint
+	CanvasIndex                 uint
+	GalleryIdentificationNumber uint
+}
+
+type CanvasSize struct {
+	CanvasHeight uint
+	CanvasWidth  uint
+}
+
+// Detail of PreviewWebtoonFromServer, which CSP sends on its own
+type DetailPreviewWebtoonFromServerResponse struct {
+	Operation   string // "ResetCanvas"
+	CanvasIndex uint
+}
+
+// Registry of all known commands and operations.
+var Registry = []Spec{
+	{
+		Command:     TellHeartbeat,
+		Description: "Send heartbeat to server for keepalive",
+		Request:     typeOf[DetailTellHeartbeatRequest](),
+		Internal:    true,
+	},
+	{
+		Command:     Authenticate,
+		Description: "Authenticate with the server",
+		Request:     typeOf[[]string](),
+		Internal:    true,
+	},
+	{
+		Command:     GetModifyKeyString,
+		Description: "Get/Set pressed modifier keys (Ctrl, Alt, Shift)",
+		Request:     typeOf[DetailGetModifyKeyStringRequest](),
+		Response:    typeOf[DetailGetModifyKeyStringResponse](),
+	},
+	{
+		Command:     GetServerSelectedTabKind,
+		Description: "Get selected tab from server",
+		Response:    typeOf[DetailGetServerSelectedTabKindResponse](),
+	},
+	{
+		Command:     SetServerSelectedTabKind,
+		Description: "When tab in remote control app is selected",
+	},
+	{
+		Command:     PreviewWebtoonFromClient,
+		Operation:   "UpdateGallery",
+		Description: "Update the webtoon preview gallery and get the size of its canvases",
+		Request:     typeOf[DetailPreviewWebtoonFromClientRequestUpdateGallery](),
+		Response:    typeOf[DetailPreviewWebtoonFromClientResponseUpdateGallery](),
+	},
+	{
+		Command:     PreviewWebtoonFromClient,
+		Operation:   "ReadPreviewBlock",
+		Description: "Read a block of a gallery canvas. The response's data is the block's RGB pixels, base64 encoded",
+		Request:     typeOf[DetailPreviewWebtoonFromClientReadPreviewBlock](),
+	},
+}
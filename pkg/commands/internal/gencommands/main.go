@@ -0,0 +1,348 @@
+// Generates the command constants, detail types, registry and docs from commands.yaml.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go/format"
+	"go/token"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+type field struct {
+	Name string `yaml:"name"`
+	Type string `yaml:"type"`
+	Doc  string `yaml:"doc"`
+}
+
+type detail struct {
+	Type    string  `yaml:"type"`
+	Doc     string  `yaml:"doc"`
+	Fields  []field `yaml:"fields"` // A struct is generated if there are any
+	Example string  `yaml:"example"`
+}
+
+type operation struct {
+	Name        string  `yaml:"name"`
+	Description string  `yaml:"description"`
+	Request     *detail `yaml:"request"`
+	Response    *detail `yaml:"response"`
+}
+
+type command struct {
+	Name        string      `yaml:"name"`
+	Description string      `yaml:"description"`
+	Internal    bool        `yaml:"internal"`
+	Request     *detail     `yaml:"request"`
+	Response    *detail     `yaml:"response"`
+	Operations  []operation `yaml:"operations"`
+	Types       []*detail   `yaml:"types"`
+}
+
+type spec struct {
+	Commands []command `yaml:"commands"`
+}
+
+// A registry entry: a command without operations, or one operation of a command.
+type entry struct {
+	command   *command
+	operation string
+	desc      string
+	request   *detail
+	response  *detail
+}
+
+func (e entry) name() string {
+	if e.operation == "" {
+		return e.command.Name
+	}
+	return e.command.Name + "/" + e.operation
+}
+
+func (s *spec) entries() []entry {
+	var entries []entry
+	for i := range s.Commands {
+		c := &s.Commands[i]
+		if len(c.Operations) == 0 {
+			entries = append(entries, entry{c, "", c.Description, c.Request, c.Response})
+		}
+		for _, op := range c.Operations {
+			entries = append(entries, entry{c, op.Name, op.Description, op.Request, op.Response})
+		}
+	}
+	return entries
+}
+
+func (s *spec) validate() error {
+	seen := make(map[string]bool)
+	types := make(map[string]bool)
+	checkDetail := func(name string, d *detail, operation string) error {
+		if d == nil {
+			return nil
+		}
+		if d.Type == "" {
+			return errors.New(name + ": detail has no type")
+		}
+		if d.Fields != nil {
+			if !token.IsIdentifier(d.Type) || !token.IsExported(d.Type) {
+				return errors.New(name + ": " + d.Type + " is not an exported type name")
+			}
+			if types[d.Type] {
+				return errors.New(name + ": type " + d.Type + " is defined twice")
+			}
+			types[d.Type] = true
+		}
+		names := make(map[string]bool)
+		for _, f := range d.Fields {
+			if !token.IsIdentifier(f.Name) || !token.IsExported(f.Name) || f.Type == "" {
+				return errors.New(name + ": invalid field " + f.Name + " in " + d.Type)
+			}
+			names[f.Name] = true
+		}
+		if operation != "" && d.Fields != nil && !names["Operation"] {
+			return errors.New(name + ": " + d.Type + " has no Operation field")
+		}
+		if d.Example != "" {
+			var example interface{}
+			if err := json.Unmarshal([]byte(d.Example), &example); err != nil {
+				return errors.Wrap(err, name+": invalid example for "+d.Type)
+			}
+			if fields, ok := example.(map[string]interface{}); ok && d.Fields != nil {
+				for key := range fields {
+					if !names[key] {
+						return errors.New(name + ": example for " + d.Type + " has unknown field " + key)
+					}
+				}
+			}
+		}
+		return nil
+	}
+
+	for _, c := range s.Commands {
+		if !token.IsIdentifier(c.Name) || !token.IsExported(c.Name) {
+			return errors.New("invalid command name " + c.Name)
+		}
+		if len(c.Operations) > 0 && (c.Request != nil || c.Response != nil) {
+			return errors.New(c.Name + ": details go in the operations when there are some")
+		}
+		for _, d := range c.Types {
+			if d.Fields == nil {
+				return errors.New(c.Name + ": extra type " + d.Type + " has no fields")
+			}
+			if err := checkDetail(c.Name, d, ""); err != nil {
+				return err
+			}
+		}
+	}
+	for _, e := range s.entries() {
+		if seen[e.name()] {
+			return errors.New(e.name() + " is defined twice")
+		}
+		seen[e.name()] = true
+		if err := checkDetail(e.name(), e.request, e.operation); err != nil {
+			return err
+		}
+		if err := checkDetail(e.name(), e.response, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Indent an example for a doc comment with tabs, like the hand-written ones, keeping its layout.
+func indentExample(example string) string {
+	lines := strings.Split(strings.TrimSpace(example), "\n")
+	for i, line := range lines {
+		trimmed := strings.TrimLeft(line, " ")
+		lines[i] = "\t" + strings.Repeat("\t", (len(line)-len(trimmed))/2) + trimmed
+	}
+	return strings.Join(lines, "\n")
+}
+
+func writeType(b *bytes.Buffer, d *detail, operation string) {
+	if d == nil || d.Fields == nil {
+		return
+	}
+	if d.Example != "" {
+		fmt.Fprintf(b, "/*\nExample:\n\n%s\n*/\n", indentExample(d.Example))
+	} else if d.Doc != "" {
+		fmt.Fprintf(b, "// %s\n", d.Doc)
+	}
+	fmt.Fprintf(b, "type %s struct {\n", d.Type)
+	for _, f := range d.Fields {
+		doc := f.Doc
+		if doc == "" && f.Name == "Operation" && operation != "" {
+			doc = strconv.Quote(operation)
+		}
+		if doc != "" {
+			doc = " // " + doc
+		}
+		fmt.Fprintf(b, "\t%s %s%s\n", f.Name, f.Type, doc)
+	}
+	b.WriteString("}\n\n")
+}
+
+func typeOf(d *detail) string {
+	return "typeOf[" + d.Type + "]()"
+}
+
+func generateGo(s *spec) ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteString("// Code generated by gencommands from commands.yaml. DO NOT EDIT.\n\npackage commands\n\n")
+
+	b.WriteString("const (\n")
+	for _, c := range s.Commands {
+		fmt.Fprintf(&b, "\t%s Command = %q // %s\n", c.Name, c.Name, c.Description)
+	}
+	b.WriteString(")\n\n")
+
+	for _, c := range s.Commands {
+		var types bytes.Buffer
+		writeType(&types, c.Request, "")
+		writeType(&types, c.Response, "")
+		for _, op := range c.Operations {
+			writeType(&types, op.Request, op.Name)
+			writeType(&types, op.Response, op.Name)
+		}
+		for _, d := range c.Types {
+			writeType(&types, d, "")
+		}
+		if types.Len() > 0 {
+			fmt.Fprintf(&b, "// Command%s //\n\n", c.Name)
+			b.Write(types.Bytes())
+		}
+	}
+
+	b.WriteString("// Registry of all known commands and operations.\nvar Registry = []Spec{\n")
+	for _, e := range s.entries() {
+		b.WriteString("\t{\n")
+		fmt.Fprintf(&b, "\t\tCommand: %s,\n", e.command.Name)
+		if e.operation != "" {
+			fmt.Fprintf(&b, "\t\tOperation: %q,\n", e.operation)
+		}
+		fmt.Fprintf(&b, "\t\tDescription: %q,\n", e.desc)
+		if e.request != nil {
+			fmt.Fprintf(&b, "\t\tRequest: %s,\n", typeOf(e.request))
+		}
+		if e.response != nil {
+			fmt.Fprintf(&b, "\t\tResponse: %s,\n", typeOf(e.response))
+		}
+		if e.command.Internal {
+			b.WriteString("\t\tInternal: true,\n")
+		}
+		b.WriteString("\t},\n")
+	}
+	b.WriteString("}\n")
+
+	src, err := format.Source(b.Bytes())
+	return src, errors.Wrap(err, "generated invalid Go")
+}
+
+func markdownCell(s string) string {
+	return strings.ReplaceAll(s, "|", "\\|")
+}
+
+func writeDetailDocs(b *bytes.Buffer, title string, d *detail, operation string) {
+	if d == nil {
+		return
+	}
+	if title != "" {
+		fmt.Fprintf(b, "%s: `%s`\n\n", title, d.Type)
+	}
+	if len(d.Fields) > 0 {
+		b.WriteString("| Field | Type | Notes |\n|---|---|---|\n")
+		for _, f := range d.Fields {
+			doc := f.Doc
+			if doc == "" && f.Name == "Operation" && operation != "" {
+				doc = "Always " + strconv.Quote(operation)
+			}
+			fmt.Fprintf(b, "| %s | `%s` | %s |\n", f.Name, f.Type, markdownCell(doc))
+		}
+		b.WriteString("\n")
+	}
+	if d.Example != "" {
+		fmt.Fprintf(b, "```json\n%s\n```\n\n", strings.TrimSpace(d.Example))
+	}
+}
+
+func generateDocs(s *spec) []byte {
+	var b bytes.Buffer
+	b.WriteString("<!-- Code generated by gencommands from commands.yaml. DO NOT EDIT. -->\n\n")
+	b.WriteString("# Commands\n\nCommands known so far. Internal ones are sent by the client itself.\n\n")
+	b.WriteString("| Command | Operation | Description | Request | Response |\n|---|---|---|---|---|\n")
+	for _, e := range s.entries() {
+		desc := e.desc
+		if e.command.Internal {
+			desc += " (internal)"
+		}
+		request, response := "", ""
+		if e.request != nil {
+			request = "`" + e.request.Type + "`"
+		}
+		if e.response != nil {
+			response = "`" + e.response.Type + "`"
+		}
+		fmt.Fprintf(&b, "| %s | %s | %s | %s | %s |\n", e.command.Name, e.operation, markdownCell(desc), markdownCell(request), markdownCell(response))
+	}
+	b.WriteString("\n")
+
+	for _, e := range s.entries() {
+		if e.request == nil && e.response == nil {
+			continue
+		}
+		fmt.Fprintf(&b, "## %s\n\n%s\n\n", e.name(), e.desc)
+		writeDetailDocs(&b, "Request", e.request, e.operation)
+		writeDetailDocs(&b, "Response", e.response, e.operation)
+	}
+	for _, c := range s.Commands {
+		for _, d := range c.Types {
+			fmt.Fprintf(&b, "## %s\n\n", d.Type)
+			if d.Doc != "" {
+				fmt.Fprintf(&b, "%s\n\n", d.Doc)
+			}
+			writeDetailDocs(&b, "", d, "")
+		}
+	}
+	return bytes.TrimSuffix(b.Bytes(), []byte("\n"))
+}
+
+func run() error {
+	specFile := flag.String("spec", "commands.yaml", "Command spec")
+	goFile := flag.String("go", "commands_gen.go", "Go file to write")
+	docsFile := flag.String("docs", "COMMANDS.md", "Markdown file to write")
+	flag.Parse()
+
+	bin, err := os.ReadFile(*specFile)
+	if err != nil {
+		return errors.Wrap(err, "failed reading spec")
+	}
+	var s spec
+	if err = yaml.Unmarshal(bin, &s); err != nil {
+		return errors.Wrap(err, "failed parsing spec")
+	}
+	if err = s.validate(); err != nil {
+		return err
+	}
+	src, err := generateGo(&s)
+	if err != nil {
+		return err
+	}
+	if err = os.WriteFile(*goFile, src, 0o644); err != nil {
+		return errors.Wrap(err, "failed writing Go")
+	}
+	return errors.Wrap(os.WriteFile(*docsFile, generateDocs(&s), 0o644), "failed writing docs")
+}
+
+func main() {
+	if err := run(); err != nil {
+		fmt.Fprintln(os.Stderr, "gencommands:", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,38 @@
+// Generates schemas.json, the JSON schemas of every registered command's details.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/chocolatkey/clipremote/pkg/commands"
+)
+
+type schemas struct {
+	Description string          `json:"description"`
+	Internal    bool            `json:"internal,omitempty"`
+	Request     commands.Schema `json:"request"`
+	Response    commands.Schema `json:"response"`
+}
+
+func main() {
+	out := flag.String("out", "schemas.json", "JSON file to write")
+	flag.Parse()
+
+	all := make(map[string]schemas)
+	for _, spec := range commands.Registry {
+		all[spec.Name()] = schemas{
+			Description: spec.Description,
+			Internal:    spec.Internal,
+			Request:     spec.RequestSchema(),
+			Response:    spec.ResponseSchema(),
+		}
+	}
+	bin, _ := json.MarshalIndent(all, "", "  ")
+	if err := os.WriteFile(*out, append(bin, '\n'), 0o644); err != nil {
+		fmt.Fprintln(os.Stderr, "genschemas:", err)
+		os.Exit(1)
+	}
+}
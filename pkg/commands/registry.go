@@ -0,0 +1,121 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// Spec describes a known command, or one operation of a command that has several.
+type Spec struct {
+	Command     Command
+	Operation   string // Value of the detail's Operation field, if the command has operations
+	Description string
+	Request     reflect.Type // Type of the detail sent. Nil if it's unknown, in which case any detail is accepted
+	Response    reflect.Type // Type of the detail received. Nil if there's none or it's unknown
+	Internal    bool         // Sent by the client itself, not meant to be sent through an API
+}
+
+// Name of the spec, like "PreviewWebtoonFromClient/UpdateGallery".
+func (s Spec) Name() string {
+	if s.Operation == "" {
+		return string(s.Command)
+	}
+	return string(s.Command) + "/" + s.Operation
+}
+
+func typeOf[T any]() reflect.Type {
+	return reflect.TypeOf((*T)(nil)).Elem()
+}
+
+// Find the spec with the given name, see Spec.Name.
+func Lookup(name string) (Spec, bool) {
+	for _, spec := range Registry {
+		if spec.Name() == name {
+			return spec, true
+		}
+	}
+	return Spec{}, false
+}
+
+// Names of all registered commands, sorted and without duplicates.
+func Names() []string {
+	seen := make(map[Command]bool)
+	var names []string
+	for _, spec := range Registry {
+		if !seen[spec.Command] {
+			seen[spec.Command] = true
+			names = append(names, string(spec.Command))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Decode and validate a JSON detail for the spec. Unknown and missing fields are errors.
+// The Operation field, if any, is filled in from the spec.
+func (s Spec) DecodeDetail(data []byte) (interface{}, error) {
+	data = bytes.TrimSpace(data)
+	if s.Request == nil {
+		if len(data) == 0 {
+			return nil, nil
+		}
+		var detail interface{}
+		if err := json.Unmarshal(data, &detail); err != nil {
+			return nil, errors.Wrap(err, "detail is not valid JSON")
+		}
+		return detail, nil
+	}
+	if len(data) == 0 {
+		data = []byte("{}")
+	}
+
+	ptr := reflect.New(s.Request)
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(ptr.Interface()); err != nil {
+		return nil, errors.Wrap(err, "invalid detail")
+	}
+
+	if s.Request.Kind() == reflect.Struct {
+		var present map[string]json.RawMessage
+		if err := json.Unmarshal(data, &present); err != nil {
+			return nil, errors.Wrap(err, "invalid detail")
+		}
+		for i := 0; i < s.Request.NumField(); i++ {
+			field := s.Request.Field(i)
+			if field.Name == "Operation" && s.Operation != "" {
+				ptr.Elem().Field(i).SetString(s.Operation)
+				continue
+			}
+			if _, ok := present[field.Name]; !ok {
+				return nil, errors.New("detail is missing field " + field.Name)
+			}
+		}
+	}
+	return ptr.Elem().Interface(), nil
+}
+
+// Operation of a detail, for commands that have several. Works for the typed details and decoded JSON objects.
+func OperationOf(detail interface{}) string {
+	switch d := detail.(type) {
+	case nil:
+		return ""
+	case map[string]interface{}:
+		op, _ := d["Operation"].(string)
+		return op
+	}
+	v := reflect.ValueOf(detail)
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if v.Kind() == reflect.Struct {
+		if field := v.FieldByName("Operation"); field.IsValid() && field.Kind() == reflect.String {
+			return field.String()
+		}
+	}
+	return ""
+}
@@ -0,0 +1,78 @@
+package commands
+
+import "reflect"
+
+// Schema is a JSON Schema, as used in OpenAPI 3.
+type Schema map[string]interface{}
+
+// Build the JSON Schema of a detail type. Field names are used as-is, like encoding/json does.
+func SchemaOf(t reflect.Type) Schema {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return SchemaOf(t.Elem())
+	case reflect.Bool:
+		return Schema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return Schema{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return Schema{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return Schema{"type": "number"}
+	case reflect.String:
+		return Schema{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return Schema{"type": "array", "items": SchemaOf(t.Elem())}
+	case reflect.Map:
+		return Schema{"type": "object", "additionalProperties": SchemaOf(t.Elem())}
+	case reflect.Struct:
+		properties := make(map[string]interface{})
+		required := make([]string, 0, t.NumField())
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			properties[field.Name] = SchemaOf(field.Type)
+			required = append(required, field.Name)
+		}
+		return Schema{
+			"type":                 "object",
+			"properties":           properties,
+			"required":             required,
+			"additionalProperties": false,
+		}
+	}
+	return Schema{} // Anything
+}
+
+// Schema of the spec's request detail. The Operation field is left out, since it's implied by the spec.
+func (s Spec) RequestSchema() Schema {
+	if s.Request == nil {
+		return Schema{}
+	}
+	schema := SchemaOf(s.Request)
+	if s.Operation == "" {
+		return schema
+	}
+	if properties, ok := schema["properties"].(map[string]interface{}); ok {
+		delete(properties, "Operation")
+	}
+	if required, ok := schema["required"].([]string); ok {
+		filtered := required[:0]
+		for _, name := range required {
+			if name != "Operation" {
+				filtered = append(filtered, name)
+			}
+		}
+		schema["required"] = filtered
+	}
+	return schema
+}
+
+// Schema of the spec's response detail.
+func (s Spec) ResponseSchema() Schema {
+	if s.Response == nil {
+		return Schema{}
+	}
+	return SchemaOf(s.Response)
+}
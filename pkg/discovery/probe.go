@@ -0,0 +1,229 @@
+// Package discovery finds commands CSP understands that aren't in pkg/commands yet, by trying
+// candidates from a wordlist and sorting out the responses.
+package discovery
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/chocolatkey/clipremote/pkg/commands"
+	"github.com/chocolatkey/clipremote/pkg/packets"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Client is the part of the client needed to probe.
+type Client interface {
+	SendCommand(command commands.Command, detail interface{}, callback packets.ClientCommandCallback)
+	Alive() bool
+	Close() error
+}
+
+// Class of a response to a candidate.
+type Class string
+
+const (
+	ClassSuccess    Class = "success"    // CSP accepted the command
+	ClassError      Class = "error"      // CSP knows the command, but refused this detail
+	ClassUnknown    Class = "unknown"    // Same answer as a made-up command, or no answer
+	ClassDisconnect Class = "disconnect" // The connection or session was lost
+)
+
+var errTimeout = errors.New("no response")
+
+type Result struct {
+	Candidate
+	Class      Class       `json:"class"`
+	Known      bool        `json:"known"` // Already in pkg/commands
+	Response   interface{} `json:"response,omitempty"`
+	DataLength int         `json:"data_length,omitempty"`
+	Error      string      `json:"error,omitempty"`
+	Duration   string      `json:"duration"`
+}
+
+type Report struct {
+	Started    time.Time `json:"started"`
+	Finished   time.Time `json:"finished"`
+	Reconnects int       `json:"reconnects"`
+	Unknown    Result    `json:"unknown"` // What a made-up command gets, to compare with
+	Results    []Result  `json:"results"`
+}
+
+// Number of results in each class.
+func (r *Report) Count() map[Class]int {
+	counts := make(map[Class]int)
+	for _, result := range r.Results {
+		counts[result.Class]++
+	}
+	return counts
+}
+
+type Options struct {
+	Interval time.Duration // Time between commands, to not flood CSP
+	Timeout  time.Duration // How long to wait for a response
+	Redials  int           // Attempts at connecting again before giving up
+	Progress func(Result)  // Called after every candidate, if set
+}
+
+var DefaultOptions = Options{
+	Interval: 200 * time.Millisecond,
+	Timeout:  5 * time.Second,
+	Redials:  5,
+}
+
+type Prober struct {
+	dial   func() (Client, error)
+	opts   Options
+	client Client
+	report *Report
+}
+
+// The dial function connects and authenticates. It's called again whenever the session is lost.
+func NewProber(dial func() (Client, error), opts Options) *Prober {
+	return &Prober{dial: dial, opts: opts}
+}
+
+// Wait for the response to a command, or give up after the timeout.
+func (p *Prober) send(command commands.Command, detail interface{}) (*packets.ServerCommand, error) {
+	type response struct {
+		scp *packets.ServerCommand
+		err error
+	}
+	done := make(chan response, 1) // Late responses must not block the client
+	p.client.SendCommand(command, detail, func(scp *packets.ServerCommand, err error) {
+		done <- response{scp, err}
+	})
+	select {
+	case r := <-done:
+		return r.scp, r.err
+	case <-time.After(p.opts.Timeout):
+		return nil, errTimeout
+	}
+}
+
+func (p *Prober) try(candidate Candidate) Result {
+	result := Result{Candidate: candidate}
+	_, result.Known = commands.Lookup(candidate.Name())
+	started := time.Now()
+	scp, err := p.send(candidate.Command, candidate.Detail)
+	result.Duration = time.Since(started).Round(time.Millisecond).String()
+
+	switch {
+	case err == errTimeout:
+		result.Class = ClassUnknown
+		result.Error = err.Error()
+	case err != nil:
+		result.Class = ClassDisconnect
+		result.Error = err.Error()
+	default:
+		result.Response = scp.Detail
+		result.DataLength = len(scp.Data)
+		result.Class = ClassSuccess
+		if scp.Type == packets.TypeServerResponseError {
+			result.Class = ClassError
+		}
+		if result.Class == p.report.Unknown.Class && sameDetail(scp.Detail, p.report.Unknown.Response) {
+			result.Class = ClassUnknown
+		}
+	}
+	return result
+}
+
+func sameDetail(a, b interface{}) bool {
+	binA, errA := json.Marshal(a) // Map keys come out sorted
+	binB, errB := json.Marshal(b)
+	return errA == nil && errB == nil && string(binA) == string(binB)
+}
+
+// Send a made-up command to see how CSP answers ones it doesn't know.
+func (p *Prober) fingerprint() {
+	random := make([]byte, 4)
+	rand.Read(random)
+	p.report.Unknown = p.try(Candidate{Command: commands.Command("ClipRemoteProbe" + hex.EncodeToString(random))})
+	logrus.Infof("made-up commands get: %s %v", p.report.Unknown.Class, p.report.Unknown.Response)
+}
+
+// Make sure the session still works after a disconnect, connecting again if it doesn't.
+func (p *Prober) recover(ctx context.Context) error {
+	// The client reconnects on its own when the connection is closed, give it a moment
+	for i := 0; i < 10 && !p.client.Alive(); i++ {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(p.opts.Interval):
+		}
+	}
+	if p.client.Alive() {
+		scp, err := p.send(commands.TellHeartbeat, commands.DetailTellHeartbeatRequest{})
+		if err == nil && scp.Type == packets.TypeServerResponseSuccess {
+			return nil
+		}
+	}
+	p.client.Close()
+
+	backoff := time.Second
+	var err error
+	for attempt := 1; attempt <= p.opts.Redials; attempt++ {
+		var client Client
+		if client, err = p.dial(); err == nil {
+			p.client = client
+			p.report.Reconnects++
+			logrus.Infoln("connected again after losing the session")
+			return nil
+		}
+		logrus.Warnf("failed connecting again (attempt %d): %v", attempt, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+	return errors.Wrap(err, "failed connecting again")
+}
+
+// Try every candidate in turn. The report is returned even when probing stops early.
+func (p *Prober) Run(ctx context.Context, candidates []Candidate) (*Report, error) {
+	p.report = &Report{Started: time.Now()}
+	defer func() { p.report.Finished = time.Now() }()
+
+	var err error
+	if p.client, err = p.dial(); err != nil {
+		return p.report, err
+	}
+	defer func() { p.client.Close() }()
+	p.fingerprint()
+	if p.report.Unknown.Class == ClassDisconnect {
+		if err = p.recover(ctx); err != nil {
+			return p.report, err
+		}
+	}
+
+	ticker := time.NewTicker(p.opts.Interval)
+	defer ticker.Stop()
+	for _, candidate := range candidates {
+		if candidate.Command == commands.Authenticate {
+			continue // Changes the password, which would lose the session
+		}
+		select {
+		case <-ctx.Done():
+			return p.report, ctx.Err()
+		case <-ticker.C:
+		}
+
+		result := p.try(candidate)
+		p.report.Results = append(p.report.Results, result)
+		if p.opts.Progress != nil {
+			p.opts.Progress(result)
+		}
+		if result.Class == ClassDisconnect {
+			if err = p.recover(ctx); err != nil {
+				return p.report, err
+			}
+		}
+	}
+	return p.report, nil
+}
@@ -0,0 +1,112 @@
+package discovery
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/chocolatkey/clipremote/pkg/commands"
+	"github.com/chocolatkey/clipremote/pkg/packets"
+	"github.com/pkg/errors"
+)
+
+// Client answering known commands with success, others with an error, and dropping the
+// session for "Drop", or every command with dropAll. Whether it comes back on its own is
+// up to revive.
+type fakeClient struct {
+	alive   atomic.Bool
+	revive  bool
+	dropAll bool
+}
+
+func (c *fakeClient) SendCommand(command commands.Command, detail interface{}, callback packets.ClientCommandCallback) {
+	if !c.alive.Load() {
+		callback(nil, errors.New("not alive"))
+		return
+	}
+	switch _, known := commands.Lookup(string(command)); {
+	case command == "Drop" || c.dropAll:
+		c.alive.Store(false)
+		if c.revive {
+			go func() {
+				time.Sleep(5 * time.Millisecond)
+				c.alive.Store(true)
+			}()
+		}
+		callback(nil, errors.New("connection closed"))
+	case known || command == commands.TellHeartbeat:
+		callback(&packets.ServerCommand{Type: packets.TypeServerResponseSuccess, Command: command}, nil)
+	default:
+		callback(&packets.ServerCommand{Type: packets.TypeServerResponseError, Command: command, Detail: map[string]interface{}{"error": "unknown"}}, nil)
+	}
+}
+
+func (c *fakeClient) Alive() bool  { return c.alive.Load() }
+func (c *fakeClient) Close() error { c.alive.Store(false); return nil }
+
+func TestProber(t *testing.T) {
+	candidates := []Candidate{
+		{Command: commands.GetServerSelectedTabKind},
+		{Command: "Drop"},
+		{Command: "MadeUp"},
+	}
+	tests := []struct {
+		name       string
+		revive     bool // The client reconnects on its own
+		dials      int  // Dials that work, after which dialing fails
+		classes    []Class
+		reconnects int
+		err        bool
+	}{
+		{"reconnects on its own", true, 1, []Class{ClassSuccess, ClassDisconnect, ClassUnknown}, 0, false},
+		{"dials again", false, 2, []Class{ClassSuccess, ClassDisconnect, ClassUnknown}, 1, false},
+		{"can't dial again", false, 1, []Class{ClassSuccess, ClassDisconnect}, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dials := 0
+			prober := NewProber(func() (Client, error) {
+				if dials++; dials > tt.dials {
+					return nil, errors.New("unreachable")
+				}
+				c := &fakeClient{revive: tt.revive}
+				c.alive.Store(true)
+				return c, nil
+			}, Options{Interval: time.Millisecond, Timeout: time.Second, Redials: 1})
+			report, err := prober.Run(context.Background(), candidates)
+			if (err != nil) != tt.err {
+				t.Errorf("got error %v", err)
+			}
+			if len(report.Results) != len(tt.classes) {
+				t.Fatalf("got %d results, want %d", len(report.Results), len(tt.classes))
+			}
+			for i, result := range report.Results {
+				if result.Class != tt.classes[i] {
+					t.Errorf("%s: got %s, want %s", result.Name(), result.Class, tt.classes[i])
+				}
+			}
+			if report.Reconnects != tt.reconnects {
+				t.Errorf("got %d reconnects, want %d", report.Reconnects, tt.reconnects)
+			}
+		})
+	}
+}
+
+func TestProberStopsWhileRecovering(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	prober := NewProber(func() (Client, error) {
+		c := &fakeClient{dropAll: true}
+		c.alive.Store(true)
+		return c, nil
+	}, Options{Interval: time.Hour, Timeout: time.Second, Redials: 1})
+	started := time.Now()
+	_, err := prober.Run(ctx, nil) // The made-up command already drops the session
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want the context's error", err)
+	}
+	if took := time.Since(started); took > time.Second {
+		t.Errorf("took %v to stop", took)
+	}
+}
@@ -0,0 +1,78 @@
+package discovery
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"strings"
+
+	"github.com/chocolatkey/clipremote/pkg/commands"
+	"github.com/pkg/errors"
+)
+
+// Candidate is a command to try, with the detail to send.
+type Candidate struct {
+	Command   commands.Command `json:"command"`
+	Operation string           `json:"operation,omitempty"`
+	Detail    interface{}      `json:"detail"`
+}
+
+// Name like "Command" or "Command/Operation", see commands.Spec.Name.
+func (c Candidate) Name() string {
+	if c.Operation == "" {
+		return string(c.Command)
+	}
+	return string(c.Command) + "/" + c.Operation
+}
+
+// Details tried for candidates that don't have their own.
+var DefaultShapes = []interface{}{nil, map[string]interface{}{}}
+
+// Copy of the detail with the Operation field set, for commands with several operations.
+func withOperation(detail interface{}, operation string) interface{} {
+	if operation == "" {
+		return detail
+	}
+	fields := map[string]interface{}{}
+	if m, ok := detail.(map[string]interface{}); ok {
+		for k, v := range m {
+			fields[k] = v
+		}
+	} else if detail != nil {
+		return detail // Operations only make sense in objects
+	}
+	fields["Operation"] = operation
+	return fields
+}
+
+// Read candidates from a wordlist. Each line is a name like "Command" or "Command/Operation",
+// optionally followed by a detail as JSON. Names without a detail are tried with every shape.
+// Empty lines and lines starting with # are skipped.
+func ParseWordlist(r io.Reader, shapes []interface{}) ([]Candidate, error) {
+	var candidates []Candidate
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, rawDetail, _ := strings.Cut(line, " ")
+		command, operation, _ := strings.Cut(name, "/")
+		candidate := Candidate{Command: commands.Command(command), Operation: operation}
+
+		if rawDetail = strings.TrimSpace(rawDetail); rawDetail != "" {
+			if err := json.Unmarshal([]byte(rawDetail), &candidate.Detail); err != nil {
+				return nil, errors.Wrapf(err, "invalid detail on line %d", lineNumber)
+			}
+			candidate.Detail = withOperation(candidate.Detail, operation)
+			candidates = append(candidates, candidate)
+			continue
+		}
+		for _, shape := range shapes {
+			candidate.Detail = withOperation(shape, operation)
+			candidates = append(candidates, candidate)
+		}
+	}
+	return candidates, errors.Wrap(scanner.Err(), "failed reading wordlist")
+}
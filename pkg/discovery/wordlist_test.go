@@ -0,0 +1,43 @@
+package discovery
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseWordlist(t *testing.T) {
+	shapes := []interface{}{nil, map[string]interface{}{}}
+	tests := []struct {
+		line string
+		want []Candidate
+		err  bool
+	}{
+		{"# comment", nil, false},
+		{"   ", nil, false},
+		{"GetThing", []Candidate{
+			{Command: "GetThing"},
+			{Command: "GetThing", Detail: map[string]interface{}{}},
+		}, false},
+		{"Preview/Read", []Candidate{
+			{Command: "Preview", Operation: "Read", Detail: map[string]interface{}{"Operation": "Read"}},
+			{Command: "Preview", Operation: "Read", Detail: map[string]interface{}{"Operation": "Read"}},
+		}, false},
+		{`Preview/Read {"Index":1}`, []Candidate{
+			{Command: "Preview", Operation: "Read", Detail: map[string]interface{}{"Operation": "Read", "Index": 1.0}},
+		}, false},
+		{`SetThing [1,2]`, []Candidate{{Command: "SetThing", Detail: []interface{}{1.0, 2.0}}}, false},
+		{`SetThing/Op [1]`, []Candidate{{Command: "SetThing", Operation: "Op", Detail: []interface{}{1.0}}}, false},
+		{`SetThing {`, nil, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseWordlist(strings.NewReader(tt.line), shapes)
+		if (err != nil) != tt.err {
+			t.Errorf("%q: got error %v", tt.line, err)
+			continue
+		}
+		if !tt.err && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %#v, want %#v", tt.line, got, tt.want)
+		}
+	}
+}
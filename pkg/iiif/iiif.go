@@ -0,0 +1,307 @@
+// Package iiif implements the parts of the IIIF Image API 3.0 needed to serve canvases to deep-zoom viewers.
+// See https://iiif.io/api/image/3.0/
+package iiif
+
+import (
+	"image"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	Context  = "http://iiif.io/api/image/3/context.json"
+	Protocol = "http://iiif.io/api/image"
+)
+
+// Info is the image information document (info.json).
+type Info struct {
+	Context         string   `json:"@context"`
+	ID              string   `json:"id"`
+	Type            string   `json:"type"`
+	Protocol        string   `json:"protocol"`
+	Profile         string   `json:"profile"`
+	Width           int      `json:"width"`
+	Height          int      `json:"height"`
+	MaxWidth        int      `json:"maxWidth,omitempty"`
+	MaxHeight       int      `json:"maxHeight,omitempty"`
+	MaxArea         int      `json:"maxArea,omitempty"`
+	Tiles           []Tile   `json:"tiles,omitempty"`
+	ExtraQualities  []string `json:"extraQualities,omitempty"`
+	ExtraFormats    []string `json:"extraFormats,omitempty"`
+	ExtraFeatures   []string `json:"extraFeatures,omitempty"`
+	PreferredFormat []string `json:"preferredFormats,omitempty"`
+}
+
+type Tile struct {
+	Width        int   `json:"width"`
+	Height       int   `json:"height,omitempty"`
+	ScaleFactors []int `json:"scaleFactors"`
+}
+
+// Limits on the size of the images served, since each one is rendered in memory.
+type Limits struct {
+	MaxArea       int // Of the image served, in pixels
+	MaxRegionArea int // Of the region read to make it, in pixels
+}
+
+// About 64MB per image, read from up to 256MB of the canvas.
+var DefaultLimits = Limits{MaxArea: 4096 * 4096, MaxRegionArea: 8192 * 8192}
+
+// Whether an image of the size is allowed. Floats so huge sizes can't overflow.
+func (l Limits) allows(width, height float64) bool {
+	return l.MaxArea <= 0 || width*height <= float64(l.MaxArea)
+}
+
+// Whether a region of the size may be read.
+func (l Limits) allowsRegion(width, height int) bool {
+	return l.MaxRegionArea <= 0 || float64(width)*float64(height) <= float64(l.MaxRegionArea)
+}
+
+// Largest size within the limits with the same aspect ratio.
+func (l Limits) fit(width, height int) (int, int) {
+	if l.allows(float64(width), float64(height)) {
+		return width, height
+	}
+	scale := math.Sqrt(float64(l.MaxArea) / (float64(width) * float64(height)))
+	return int(float64(width) * scale), int(float64(height) * scale)
+}
+
+// Build the info.json of an image of the given size, tiled the way it's stored.
+// Only scale factors whose tiles cover a region within the limits are listed.
+func NewInfo(id string, width, height, tileWidth, tileHeight int, limits Limits) Info {
+	var factors []int
+	for f := 1; f == 1 || (width/f >= 1 && height/f >= tileHeight/2); f *= 2 {
+		if f > 1 && !limits.allowsRegion(min(tileWidth*f, width), min(tileHeight*f, height)) {
+			break
+		}
+		factors = append(factors, f)
+	}
+	return Info{
+		Context:  Context,
+		ID:       id,
+		Type:     "ImageService3",
+		Protocol: Protocol,
+		Profile:  "level1",
+		Width:    width,
+		Height:   height,
+		MaxArea:  limits.MaxArea,
+		Tiles: []Tile{{
+			Width:        tileWidth,
+			Height:       tileHeight,
+			ScaleFactors: factors,
+		}},
+		ExtraQualities:  []string{"color", "gray", "bitonal"},
+		ExtraFormats:    []string{"png"},
+		ExtraFeatures:   []string{"mirroring", "regionByPct", "regionSquare", "rotationBy90s", "sizeByConfinedWh", "sizeByPct", "sizeByWh", "sizeUpscaling"},
+		PreferredFormat: []string{"png"},
+	}
+}
+
+// Request is a parsed image request: {region}/{size}/{rotation}/{quality}.{format}
+type Request struct {
+	Region   image.Rectangle // Clipped to the image
+	Width    int             // Size of the output before rotation
+	Height   int
+	Mirror   bool
+	Rotation int // One of 0, 90, 180, 270
+	Quality  string
+	Format   string
+}
+
+var ErrNotImplemented = errors.New("not implemented")
+
+// Parse the path segments of an image request for an image of the given size.
+// Errors wrapping ErrNotImplemented are valid requests this implementation can't fulfill.
+// Sizes and regions above the limits are errors, except for max and !w,h which are scaled
+// down to fit.
+func ParseRequest(region, size, rotation, qualityFormat string, width, height int, limits Limits) (*Request, error) {
+	req := &Request{}
+	var err error
+
+	if req.Region, err = parseRegion(region, width, height); err != nil {
+		return nil, err
+	}
+	if !limits.allowsRegion(req.Region.Dx(), req.Region.Dy()) {
+		return nil, errors.New("region is larger than the maximum area of " + strconv.Itoa(limits.MaxRegionArea) + " pixels")
+	}
+	if req.Width, req.Height, err = parseSize(size, req.Region.Dx(), req.Region.Dy(), limits); err != nil {
+		return nil, err
+	}
+
+	if strings.HasPrefix(rotation, "!") {
+		req.Mirror = true
+		rotation = rotation[1:]
+	}
+	degrees, err := strconv.ParseFloat(rotation, 64)
+	if err != nil || degrees < 0 || degrees > 360 {
+		return nil, errors.New("invalid rotation " + rotation)
+	}
+	if math.Mod(degrees, 90) != 0 {
+		return nil, errors.Wrap(ErrNotImplemented, "rotation by arbitrary angles")
+	}
+	req.Rotation = int(degrees) % 360
+
+	dot := strings.LastIndexByte(qualityFormat, '.')
+	if dot < 0 {
+		return nil, errors.New("missing format")
+	}
+	req.Quality, req.Format = qualityFormat[:dot], qualityFormat[dot+1:]
+	switch req.Quality {
+	case "default", "color", "gray", "bitonal":
+	default:
+		return nil, errors.New("invalid quality " + req.Quality)
+	}
+	switch req.Format {
+	case "jpg", "png":
+	case "tif", "gif", "pdf", "jp2", "webp":
+		return nil, errors.Wrap(ErrNotImplemented, "format "+req.Format)
+	default:
+		return nil, errors.New("invalid format " + req.Format)
+	}
+
+	return req, nil
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func parseInts(s string, n int) ([]int, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != n {
+		return nil, errors.New("expected " + strconv.Itoa(n) + " values in " + s)
+	}
+	nums := make([]int, n)
+	for i, part := range parts {
+		num, err := strconv.Atoi(part)
+		if err != nil || num < 0 {
+			return nil, errors.New("invalid value " + part)
+		}
+		nums[i] = num
+	}
+	return nums, nil
+}
+
+func parseFloats(s string, n int) ([]float64, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != n {
+		return nil, errors.New("expected " + strconv.Itoa(n) + " values in " + s)
+	}
+	nums := make([]float64, n)
+	for i, part := range parts {
+		num, err := strconv.ParseFloat(part, 64)
+		if err != nil || num < 0 {
+			return nil, errors.New("invalid value " + part)
+		}
+		nums[i] = num
+	}
+	return nums, nil
+}
+
+func parseRegion(region string, width, height int) (image.Rectangle, error) {
+	bounds := image.Rect(0, 0, width, height)
+	var r image.Rectangle
+	switch {
+	case region == "full":
+		return bounds, nil
+	case region == "square":
+		if width > height {
+			r = image.Rect((width-height)/2, 0, (width-height)/2+height, height)
+		} else {
+			r = image.Rect(0, (height-width)/2, width, (height-width)/2+width)
+		}
+		return r, nil
+	case strings.HasPrefix(region, "pct:"):
+		nums, err := parseFloats(region[4:], 4)
+		if err != nil {
+			return r, errors.Wrap(err, "invalid region")
+		}
+		r = image.Rect(
+			int(nums[0]*float64(width)/100),
+			int(nums[1]*float64(height)/100),
+			int((nums[0]+nums[2])*float64(width)/100),
+			int((nums[1]+nums[3])*float64(height)/100),
+		)
+	default:
+		nums, err := parseInts(region, 4)
+		if err != nil {
+			return r, errors.Wrap(err, "invalid region")
+		}
+		r = image.Rect(nums[0], nums[1], nums[0]+nums[2], nums[1]+nums[3])
+	}
+	r = r.Intersect(bounds)
+	if r.Empty() {
+		return r, errors.New("region is empty or outside of the image")
+	}
+	return r, nil
+}
+
+func parseSize(size string, width, height int, limits Limits) (int, int, error) {
+	upscale := strings.HasPrefix(size, "^")
+	if upscale {
+		size = size[1:]
+	}
+
+	var w, h int
+	switch {
+	case size == "max":
+		w, h = limits.fit(width, height)
+	case strings.HasPrefix(size, "pct:"):
+		pct, err := strconv.ParseFloat(size[4:], 64)
+		if err != nil || pct <= 0 {
+			return 0, 0, errors.New("invalid size " + size)
+		}
+		if !limits.allows(float64(width)*pct/100, float64(height)*pct/100) {
+			return 0, 0, errors.New("size is larger than the maximum area of " + strconv.Itoa(limits.MaxArea) + " pixels")
+		}
+		w, h = int(float64(width)*pct/100), int(float64(height)*pct/100)
+	case strings.HasPrefix(size, "!"):
+		nums, err := parseInts(size[1:], 2)
+		if err != nil {
+			return 0, 0, errors.Wrap(err, "invalid size")
+		}
+		// Largest size that fits in the box, keeping the aspect ratio
+		scale := math.Min(float64(nums[0])/float64(width), float64(nums[1])/float64(height))
+		if !upscale && scale > 1 {
+			scale = 1
+		}
+		w, h = limits.fit(int(float64(width)*scale), int(float64(height)*scale))
+	case strings.HasSuffix(size, ","):
+		nums, err := parseInts(strings.TrimSuffix(size, ","), 1)
+		if err != nil {
+			return 0, 0, errors.Wrap(err, "invalid size")
+		}
+		w = nums[0]
+		h = int(math.Round(float64(height) * float64(w) / float64(width)))
+	case strings.HasPrefix(size, ","):
+		nums, err := parseInts(strings.TrimPrefix(size, ","), 1)
+		if err != nil {
+			return 0, 0, errors.Wrap(err, "invalid size")
+		}
+		h = nums[0]
+		w = int(math.Round(float64(width) * float64(h) / float64(height)))
+	default:
+		nums, err := parseInts(size, 2)
+		if err != nil {
+			return 0, 0, errors.Wrap(err, "invalid size")
+		}
+		w, h = nums[0], nums[1]
+	}
+
+	if w < 1 || h < 1 {
+		return 0, 0, errors.New("size " + size + " is empty")
+	}
+	if !upscale && (w > width || h > height) {
+		return 0, 0, errors.New("size is larger than the region, use ^ to upscale")
+	}
+	if !limits.allows(float64(w), float64(h)) {
+		return 0, 0, errors.New("size is larger than the maximum area of " + strconv.Itoa(limits.MaxArea) + " pixels")
+	}
+	return w, h, nil
+}
@@ -0,0 +1,136 @@
+package iiif
+
+import (
+	"image"
+	"reflect"
+	"testing"
+)
+
+func TestParseRegion(t *testing.T) {
+	tests := []struct {
+		region string
+		want   image.Rectangle
+		err    bool
+	}{
+		{"full", image.Rect(0, 0, 200, 100), false},
+		{"square", image.Rect(50, 0, 150, 100), false},
+		{"10,20,30,40", image.Rect(10, 20, 40, 60), false},
+		{"150,50,100,100", image.Rect(150, 50, 200, 100), false}, // Clipped
+		{"pct:50,50,50,50", image.Rect(100, 50, 200, 100), false},
+		{"300,0,10,10", image.Rectangle{}, true},
+		{"0,0,0,10", image.Rectangle{}, true},
+		{"1,2,3", image.Rectangle{}, true},
+		{"-1,0,10,10", image.Rectangle{}, true},
+		{"pct:a,0,10,10", image.Rectangle{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseRegion(tt.region, 200, 100)
+		if (err != nil) != tt.err {
+			t.Errorf("%s: got error %v", tt.region, err)
+			continue
+		}
+		if !tt.err && got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.region, got, tt.want)
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	limits := Limits{MaxArea: 400 * 400}
+	tests := []struct {
+		size          string
+		width, height int
+		w, h          int
+		err           bool
+	}{
+		{"max", 200, 100, 200, 100, false},
+		{"max", 800, 800, 400, 400, false}, // Scaled down to the limit
+		{"^max", 200, 100, 200, 100, false},
+		{"pct:50", 200, 100, 100, 50, false},
+		{"pct:0", 200, 100, 0, 0, true},
+		{"pct:200", 200, 100, 0, 0, true}, // Upscaling without ^
+		{"^pct:200", 200, 100, 400, 200, false},
+		{"^pct:1000", 200, 100, 0, 0, true}, // Above the limit
+		{"100,", 200, 100, 100, 50, false},
+		{",50", 200, 100, 100, 50, false},
+		{"100,20", 200, 100, 100, 20, false},
+		{"0,20", 200, 100, 0, 0, true},
+		{"100,0", 200, 100, 0, 0, true},
+		{"0,", 200, 100, 0, 0, true},
+		{"1,", 200, 1, 0, 0, true}, // Rounds to a height of 0
+		{"300,100", 200, 100, 0, 0, true},
+		{"^300,100", 200, 100, 300, 100, false},
+		{"!100,100", 200, 100, 100, 50, false},
+		{"!1000,1000", 200, 100, 200, 100, false}, // Not larger than the region
+		{"^!300,300", 200, 100, 300, 150, false},
+		{"^!1000,1000", 200, 100, 565, 282, false}, // As large as the limit allows
+		{"!0,100", 200, 100, 0, 0, true},
+		{"abc", 200, 100, 0, 0, true},
+		{"10,20,30", 200, 100, 0, 0, true},
+	}
+	for _, tt := range tests {
+		w, h, err := parseSize(tt.size, tt.width, tt.height, limits)
+		if (err != nil) != tt.err {
+			t.Errorf("%s of %dx%d: got error %v", tt.size, tt.width, tt.height, err)
+			continue
+		}
+		if !tt.err && (w != tt.w || h != tt.h) {
+			t.Errorf("%s of %dx%d: got %dx%d, want %dx%d", tt.size, tt.width, tt.height, w, h, tt.w, tt.h)
+		}
+	}
+}
+
+func TestParseRequest(t *testing.T) {
+	limits := Limits{MaxArea: 1000 * 1000, MaxRegionArea: 1000 * 2000}
+	tests := []struct {
+		path [4]string
+		want *Request
+		err  bool
+	}{
+		{[4]string{"full", "max", "0", "default.png"}, &Request{Region: image.Rect(0, 0, 1000, 2000), Width: 707, Height: 1414, Quality: "default", Format: "png"}, false},
+		{[4]string{"0,0,1000,1000", "500,", "!90", "gray.jpg"}, &Request{Region: image.Rect(0, 0, 1000, 1000), Width: 500, Height: 500, Mirror: true, Rotation: 90, Quality: "gray", Format: "jpg"}, false},
+		{[4]string{"full", "max", "360", "default.png"}, &Request{Region: image.Rect(0, 0, 1000, 2000), Width: 707, Height: 1414, Quality: "default", Format: "png"}, false},
+		{[4]string{"full", "max", "45", "default.png"}, nil, true},
+		{[4]string{"full", "max", "0", "default"}, nil, true},
+		{[4]string{"full", "max", "0", "sepia.png"}, nil, true},
+		{[4]string{"full", "max", "0", "default.webp"}, nil, true},
+		{[4]string{"full", "0,", "0", "default.png"}, nil, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseRequest(tt.path[0], tt.path[1], tt.path[2], tt.path[3], 1000, 2000, limits)
+		if (err != nil) != tt.err {
+			t.Errorf("%v: got error %v", tt.path, err)
+			continue
+		}
+		if !tt.err && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%v: got %+v, want %+v", tt.path, got, tt.want)
+		}
+	}
+
+	// A canvas taller than the region limit can only be read in parts
+	if _, err := ParseRequest("full", "pct:10", "0", "default.png", 1000, 3000, limits); err == nil {
+		t.Error("region larger than the limit allowed")
+	}
+	if _, err := ParseRequest("0,0,1000,2000", "pct:10", "0", "default.png", 1000, 3000, limits); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNewInfoScaleFactors(t *testing.T) {
+	tests := []struct {
+		width, height int
+		limits        Limits
+		want          []int
+	}{
+		{690, 1024, Limits{}, []int{1, 2}},
+		{690, 8192, Limits{}, []int{1, 2, 4, 8, 16}},
+		{690, 8192, Limits{MaxRegionArea: 690 * 4096}, []int{1, 2, 4}},
+		{690, 8192, Limits{MaxRegionArea: 1}, []int{1}}, // Full resolution tiles are always listed
+	}
+	for _, tt := range tests {
+		info := NewInfo("id", tt.width, tt.height, tt.width, 1024, tt.limits)
+		if got := info.Tiles[0].ScaleFactors; !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%dx%d with %+v: got %v, want %v", tt.width, tt.height, tt.limits, got, tt.want)
+		}
+	}
+}
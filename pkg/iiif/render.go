@@ -0,0 +1,84 @@
+package iiif
+
+import (
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+
+	"golang.org/x/image/draw"
+)
+
+// Apply the size, rotation and quality of the request to the already cropped region.
+func (req *Request) Render(region image.Image) image.Image {
+	b := region.Bounds()
+	scaled := image.NewRGBA(image.Rect(0, 0, req.Width, req.Height))
+	if req.Width == b.Dx() && req.Height == b.Dy() {
+		draw.Copy(scaled, image.Point{}, region, b, draw.Src, nil)
+	} else {
+		draw.ApproxBiLinear.Scale(scaled, scaled.Bounds(), region, b, draw.Src, nil)
+	}
+
+	var out image.Image = rotate(scaled, req.Mirror, req.Rotation)
+	switch req.Quality {
+	case "gray":
+		gray := image.NewGray(out.Bounds())
+		draw.Copy(gray, image.Point{}, out, out.Bounds(), draw.Src, nil)
+		out = gray
+	case "bitonal":
+		bitonal := image.NewPaletted(out.Bounds(), color.Palette{color.Black, color.White})
+		draw.Copy(bitonal, image.Point{}, out, out.Bounds(), draw.Src, nil)
+		out = bitonal
+	}
+	return out
+}
+
+// Mirror horizontally (first) then rotate clockwise by a multiple of 90 degrees.
+func rotate(src *image.RGBA, mirror bool, degrees int) *image.RGBA {
+	if !mirror && degrees == 0 {
+		return src
+	}
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	dw, dh := w, h
+	if degrees == 90 || degrees == 270 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			sx := x
+			if mirror {
+				sx = w - 1 - x
+			}
+			var dx, dy int
+			switch degrees {
+			case 0:
+				dx, dy = x, y
+			case 90:
+				dx, dy = h-1-y, x
+			case 180:
+				dx, dy = w-1-x, h-1-y
+			case 270:
+				dx, dy = y, w-1-x
+			}
+			si := src.PixOffset(sx, y)
+			copy(dst.Pix[dst.PixOffset(dx, dy):], src.Pix[si:si+4])
+		}
+	}
+	return dst
+}
+
+func ContentType(format string) string {
+	if format == "png" {
+		return "image/png"
+	}
+	return "image/jpeg"
+}
+
+func Encode(w io.Writer, img image.Image, format string) error {
+	if format == "png" {
+		return png.Encode(w, img)
+	}
+	return jpeg.Encode(w, img, &jpeg.Options{Quality: 90})
+}
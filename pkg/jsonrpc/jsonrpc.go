@@ -0,0 +1,276 @@
+// Package jsonrpc implements a JSON-RPC 2.0 server, over HTTP or a stream such as stdin/stdout.
+// See https://www.jsonrpc.org/specification
+package jsonrpc
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+)
+
+const Version = "2.0"
+
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeInternalError  = -32603
+	// -32000 to -32099 are reserved for implementation-defined server errors
+)
+
+type Request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	ID      json.RawMessage `json:"id,omitempty"` // Absent for notifications
+}
+
+// Notifications are requests without an ID, and get no response.
+func (r Request) IsNotification() bool {
+	return len(r.ID) == 0
+}
+
+type Response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+type Error struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Notification sent by the server, for example for events it wants to push.
+type Notification struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params,omitempty"`
+}
+
+// Handler runs a method call. Return an *Error to control the error code, other errors are internal errors.
+// The context is the HTTP request's when served over HTTP.
+type Handler func(ctx context.Context, method string, params json.RawMessage) (interface{}, error)
+
+type Server struct {
+	Handler Handler
+}
+
+func (s *Server) call(ctx context.Context, req Request) *Response {
+	resp := &Response{JSONRPC: Version, ID: req.ID}
+	if req.JSONRPC != Version || req.Method == "" {
+		resp.Error = &Error{Code: CodeInvalidRequest, Message: "Invalid Request"}
+		if len(resp.ID) == 0 {
+			resp.ID = json.RawMessage("null")
+		}
+		return resp
+	}
+
+	result, err := s.Handler(ctx, req.Method, req.Params)
+	if req.IsNotification() {
+		return nil
+	}
+	if err != nil {
+		if rpcErr, ok := err.(*Error); ok {
+			resp.Error = rpcErr
+		} else {
+			resp.Error = &Error{Code: CodeInternalError, Message: err.Error()}
+		}
+		return resp
+	}
+	if result == nil {
+		result = json.RawMessage("null") // result is required on success
+	}
+	resp.Result = result
+	return resp
+}
+
+func parseError() *Response {
+	return &Response{
+		JSONRPC: Version,
+		Error:   &Error{Code: CodeParseError, Message: "Parse error"},
+		ID:      json.RawMessage("null"),
+	}
+}
+
+// Handle a single message, which may be a batch. Returns nil if nothing needs to be sent back.
+func (s *Server) Handle(ctx context.Context, data []byte) []byte {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '[' {
+		var batch []json.RawMessage
+		if err := json.Unmarshal(data, &batch); err != nil {
+			bin, _ := json.Marshal(parseError())
+			return bin
+		}
+		if len(batch) == 0 {
+			bin, _ := json.Marshal(&Response{
+				JSONRPC: Version,
+				Error:   &Error{Code: CodeInvalidRequest, Message: "Invalid Request"},
+				ID:      json.RawMessage("null"),
+			})
+			return bin
+		}
+		// Calls in a batch are independent, so they're run concurrently
+		all := make([]*Response, len(batch))
+		var wg sync.WaitGroup
+		for i, item := range batch {
+			var req Request
+			if err := json.Unmarshal(item, &req); err != nil {
+				all[i] = &Response{
+					JSONRPC: Version,
+					Error:   &Error{Code: CodeInvalidRequest, Message: "Invalid Request"},
+					ID:      json.RawMessage("null"),
+				}
+				continue
+			}
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				all[i] = s.call(ctx, req)
+			}(i)
+		}
+		wg.Wait()
+
+		var responses []*Response
+		for _, resp := range all {
+			if resp != nil {
+				responses = append(responses, resp)
+			}
+		}
+		if len(responses) == 0 {
+			return nil
+		}
+		bin, _ := json.Marshal(responses)
+		return bin
+	}
+
+	var req Request
+	if err := json.Unmarshal(data, &req); err != nil {
+		bin, _ := json.Marshal(parseError())
+		return bin
+	}
+	resp := s.call(ctx, req)
+	if resp == nil {
+		return nil
+	}
+	bin, _ := json.Marshal(resp)
+	return bin
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	data, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, "Bad request body", http.StatusBadRequest)
+		return
+	}
+	resp := s.Handle(r.Context(), data)
+	if resp == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("content-type", "application/json")
+	w.Write(resp)
+}
+
+// Serve newline-delimited messages read from r, writing responses and notifications to w.
+// Each message is handled as soon as it's read, so a slow call doesn't hold up the next ones,
+// and responses are written in the order they're ready. Returns when r is exhausted and every
+// call has been answered, or as soon as writing fails.
+func (s *Server) ServeStream(r io.Reader, w io.Writer, notifications <-chan Notification) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var mu sync.Mutex
+	var writeErr error
+	write := func(data []byte) {
+		mu.Lock()
+		defer mu.Unlock()
+		if writeErr != nil {
+			return
+		}
+		if _, err := w.Write(append(data, '\n')); err != nil {
+			writeErr = err
+			cancel() // Nobody is listening anymore
+		}
+	}
+
+	if notifications != nil {
+		go func() {
+			for notification := range notifications {
+				if ctx.Err() != nil {
+					continue // Keep draining so the sender isn't stuck
+				}
+				notification.JSONRPC = Version
+				bin, err := json.Marshal(notification)
+				if err != nil {
+					continue
+				}
+				write(bin)
+			}
+		}()
+	}
+
+	// Reading blocks for as long as the other end keeps r open, so it's done separately
+	// to be able to stop as soon as writing fails
+	lines := make(chan []byte)
+	var scanErr error
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), 16<<20)
+		for scanner.Scan() {
+			if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+				continue
+			}
+			select {
+			case lines <- append([]byte(nil), scanner.Bytes()...): // The scanner reuses its buffer
+			case <-ctx.Done():
+				return
+			}
+		}
+		scanErr = scanner.Err()
+	}()
+
+	var wg sync.WaitGroup
+	for reading := true; reading; {
+		select {
+		case data, ok := <-lines:
+			if !ok {
+				reading = false
+				continue
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if resp := s.Handle(ctx, data); resp != nil {
+					write(resp)
+				}
+			}()
+		case <-ctx.Done():
+			reading = false
+		}
+	}
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if writeErr != nil {
+		return writeErr
+	}
+	return scanErr
+}
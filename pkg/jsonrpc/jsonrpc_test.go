@@ -0,0 +1,152 @@
+package jsonrpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func echo(ctx context.Context, method string, params json.RawMessage) (interface{}, error) {
+	switch method {
+	case "echo":
+		return params, nil
+	case "nothing":
+		return nil, nil
+	case "fail":
+		return nil, errors.New("failed")
+	case "forbidden":
+		return nil, &Error{Code: -32001, Message: "Forbidden"}
+	}
+	return nil, &Error{Code: CodeMethodNotFound, Message: "Method not found"}
+}
+
+func TestHandle(t *testing.T) {
+	tests := []struct {
+		request string
+		want    string
+	}{
+		{`{"jsonrpc":"2.0","method":"echo","params":[1],"id":1}`, `{"jsonrpc":"2.0","result":[1],"id":1}`},
+		{`{"jsonrpc":"2.0","method":"nothing","id":"a"}`, `{"jsonrpc":"2.0","result":null,"id":"a"}`},
+		{`{"jsonrpc":"2.0","method":"fail","id":1}`, `{"jsonrpc":"2.0","error":{"code":-32603,"message":"failed"},"id":1}`},
+		{`{"jsonrpc":"2.0","method":"forbidden","id":1}`, `{"jsonrpc":"2.0","error":{"code":-32001,"message":"Forbidden"},"id":1}`},
+		{`{"jsonrpc":"2.0","method":"echo","params":[1]}`, ``}, // Notification
+		{`{"jsonrpc":"1.0","method":"echo","id":1}`, `{"jsonrpc":"2.0","error":{"code":-32600,"message":"Invalid Request"},"id":1}`},
+		{`{"jsonrpc":"2.0","method":""}`, `{"jsonrpc":"2.0","error":{"code":-32600,"message":"Invalid Request"},"id":null}`},
+		{`{`, `{"jsonrpc":"2.0","error":{"code":-32700,"message":"Parse error"},"id":null}`},
+		{`[]`, `{"jsonrpc":"2.0","error":{"code":-32600,"message":"Invalid Request"},"id":null}`},
+		{`[{"jsonrpc":"2.0","method":"echo","params":1,"id":1},1,{"jsonrpc":"2.0","method":"echo"}]`, `[{"jsonrpc":"2.0","result":1,"id":1},{"jsonrpc":"2.0","error":{"code":-32600,"message":"Invalid Request"},"id":null}]`},
+		{`[{"jsonrpc":"2.0","method":"echo"}]`, ``}, // Only notifications
+	}
+	s := &Server{Handler: echo}
+	for _, tt := range tests {
+		if got := string(s.Handle(context.Background(), []byte(tt.request))); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.request, got, tt.want)
+		}
+	}
+}
+
+// Writer safe to read from while the server writes.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestServeStreamDoesntWaitForSlowCalls(t *testing.T) {
+	release := make(chan struct{})
+	s := &Server{Handler: func(ctx context.Context, method string, params json.RawMessage) (interface{}, error) {
+		if method == "slow" {
+			<-release
+		}
+		return method, nil
+	}}
+	r, w := io.Pipe()
+	out := &lockedBuffer{}
+	done := make(chan error)
+	go func() { done <- s.ServeStream(r, out, nil) }()
+
+	io.WriteString(w, `{"jsonrpc":"2.0","method":"slow","id":1}`+"\n\n"+`{"jsonrpc":"2.0","method":"fast","id":2}`+"\n")
+	deadline := time.Now().Add(time.Second)
+	for !strings.Contains(out.String(), `"fast"`) {
+		if time.Now().After(deadline) {
+			t.Fatal("fast call waited for the slow one")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	w.Close()
+	select {
+	case <-done:
+		t.Fatal("returned before the slow call was answered")
+	case <-time.After(10 * time.Millisecond):
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 || lines[0] != `{"jsonrpc":"2.0","result":"fast","id":2}` || lines[1] != `{"jsonrpc":"2.0","result":"slow","id":1}` {
+		t.Errorf("got %q", lines)
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) { return 0, io.ErrClosedPipe }
+
+func TestServeStreamStopsWhenWritingFails(t *testing.T) {
+	s := &Server{Handler: func(ctx context.Context, method string, params json.RawMessage) (interface{}, error) {
+		if method == "wait" {
+			<-ctx.Done() // Canceled once nothing can be written anymore
+		}
+		return nil, ctx.Err()
+	}}
+	r, w := io.Pipe()
+	defer w.Close()
+	done := make(chan error)
+	go func() { done <- s.ServeStream(r, failingWriter{}, nil) }()
+	io.WriteString(w, `{"jsonrpc":"2.0","method":"wait","id":1}`+"\n"+`{"jsonrpc":"2.0","method":"now","id":2}`+"\n")
+	go io.WriteString(w, `{"jsonrpc":"2.0","method":"now","id":3}`+"\n") // Read only if the server isn't stopped yet
+	select {
+	case err := <-done:
+		if !errors.Is(err, io.ErrClosedPipe) {
+			t.Errorf("got %v, want the write error", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("didn't stop")
+	}
+}
+
+func TestServeStreamNotifications(t *testing.T) {
+	notifications := make(chan Notification)
+	r, w := io.Pipe()
+	out := &lockedBuffer{}
+	done := make(chan error)
+	go func() { done <- (&Server{Handler: echo}).ServeStream(r, out, notifications) }()
+	notifications <- Notification{Method: "event", Params: 1}
+	w.Close()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	notifications <- Notification{Method: "event", Params: 2} // Still drained, not written
+	close(notifications)
+	if got, want := out.String(), `{"jsonrpc":"2.0","method":"event","params":1}`+"\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,41 @@
+package packets
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/chocolatkey/clipremote/pkg/commands"
+)
+
+// ServerError is an error response from CSP, with the detail it sent.
+type ServerError struct {
+	Command commands.Command
+	Serial  Serial
+	Detail  interface{}
+	Err     error // More specific reason, like clipremote.ErrAuthFailed, if there is one
+}
+
+func (e *ServerError) Error() string {
+	msg := fmt.Sprintf("CSP refused %s (serial %d)", e.Command, e.Serial)
+	if e.Err != nil {
+		msg = e.Err.Error() + ": " + msg
+	}
+	if e.Detail != nil {
+		if bin, err := json.Marshal(e.Detail); err == nil {
+			msg += ": " + string(bin)
+		}
+	}
+	return msg
+}
+
+func (e *ServerError) Unwrap() error {
+	return e.Err
+}
+
+// Err is a *ServerError if the packet is an error response, nil otherwise.
+func (p *ServerCommand) Err() error {
+	if p.Type != TypeServerResponseError {
+		return nil
+	}
+	return &ServerError{Command: p.Command, Serial: p.Serial, Detail: p.Detail}
+}
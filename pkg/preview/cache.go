@@ -0,0 +1,137 @@
+package preview
+
+import (
+	"container/list"
+	"image"
+	"image/draw"
+	"sync"
+
+	"github.com/chocolatkey/clipremote/pkg/commands"
+)
+
+type BlockKey struct {
+	Source                      string // Which server the block is from, when a cache is shared, see BlockCache.Scope
+	GalleryIdentificationNumber uint
+	CanvasIndex                 uint
+	BlockIndex                  uint
+}
+
+type cachedBlock struct {
+	key BlockKey
+	img *image.RGBA
+}
+
+type blockStore struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List // Front is most recently used
+	items    map[BlockKey]*list.Element
+}
+
+// BlockCache keeps the most recently read preview blocks in memory. Blocks are only dropped
+// to make room, so clear the ones of a canvas when CSP says it changed.
+type BlockCache struct {
+	*blockStore
+	source string
+}
+
+// Create a cache holding at most capacity blocks.
+func NewBlockCache(capacity int) *BlockCache {
+	return &BlockCache{blockStore: &blockStore{
+		capacity: capacity,
+		order:    list.New(),
+		items:    make(map[BlockKey]*list.Element),
+	}}
+}
+
+// Cache sharing c's memory and capacity, for blocks read from another server.
+func (c *BlockCache) Scope(source string) *BlockCache {
+	return &BlockCache{blockStore: c.blockStore, source: source}
+}
+
+func (c *BlockCache) Get(key BlockKey) (*image.RGBA, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.order.MoveToFront(el)
+		return el.Value.(*cachedBlock).img, true
+	}
+	return nil, false
+}
+
+func (c *BlockCache) Put(key BlockKey, img *image.RGBA) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		el.Value.(*cachedBlock).img = img
+		c.order.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.order.PushFront(&cachedBlock{key: key, img: img})
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*cachedBlock).key)
+	}
+}
+
+func (c *BlockCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// Remove the blocks of the cache's source.
+func (c *BlockCache) Clear() {
+	c.remove(func(key BlockKey) bool { return true })
+}
+
+// Remove the blocks of the cache's source from a canvas, in any gallery.
+func (c *BlockCache) ClearCanvas(canvasIndex uint) {
+	c.remove(func(key BlockKey) bool { return key.CanvasIndex == canvasIndex })
+}
+
+// Remove the blocks of the cache's source that match.
+func (c *BlockCache) remove(match func(key BlockKey) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, el := range c.items {
+		if key.Source == c.source && match(key) {
+			c.order.Remove(el)
+			delete(c.items, key)
+		}
+	}
+}
+
+// Read a block, going to the server only if it isn't cached yet.
+func (c *BlockCache) ReadBlock(s Sender, galleryIdentificationNumber uint, canvasIndex uint, blockIndex uint, block image.Rectangle) (*image.RGBA, error) {
+	key := BlockKey{c.source, galleryIdentificationNumber, canvasIndex, blockIndex}
+	if img, ok := c.Get(key); ok {
+		return img, nil
+	}
+	img, err := ReadBlock(s, galleryIdentificationNumber, canvasIndex, blockIndex, block)
+	if err != nil {
+		return nil, err
+	}
+	c.Put(key, img)
+	return img, nil
+}
+
+// Read part of a canvas, fetching only the blocks that overlap the region.
+// The returned image has the same bounds as the region (clipped to the canvas).
+func (c *BlockCache) ReadRegion(s Sender, galleryIdentificationNumber uint, canvasIndex uint, size commands.CanvasSize, region image.Rectangle) (*image.RGBA, error) {
+	region = region.Intersect(image.Rect(0, 0, int(size.CanvasWidth), int(size.CanvasHeight)))
+	dst := image.NewRGBA(region)
+	for i, block := range Blocks(size) {
+		if !block.Overlaps(region) {
+			continue
+		}
+		img, err := c.ReadBlock(s, galleryIdentificationNumber, canvasIndex, uint(i), block)
+		if err != nil {
+			return nil, err
+		}
+		r := block.Intersect(region)
+		draw.Draw(dst, r, img, r.Min, draw.Src)
+	}
+	return dst, nil
+}
@@ -0,0 +1,87 @@
+package preview
+
+import (
+	"image"
+	"testing"
+
+	"github.com/chocolatkey/clipremote/pkg/commands"
+)
+
+func TestBlockCacheClear(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 1, 1))
+	tests := []struct {
+		name  string
+		clear func(a, b *BlockCache)
+		left  []BlockKey
+	}{
+		{"everything of a source", func(a, b *BlockCache) { a.Clear() }, []BlockKey{{"b", 1, 0, 0}}},
+		{"a canvas", func(a, b *BlockCache) { a.ClearCanvas(1) }, []BlockKey{{"a", 1, 0, 0}, {"a", 1, 0, 1}, {"b", 1, 0, 0}}},
+		{"a canvas of another source", func(a, b *BlockCache) { b.ClearCanvas(1) }, []BlockKey{{"a", 1, 0, 0}, {"a", 1, 0, 1}, {"a", 1, 1, 0}, {"a", 2, 1, 0}, {"b", 1, 0, 0}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := NewBlockCache(10)
+			a, b := cache.Scope("a"), cache.Scope("b")
+			for _, key := range []BlockKey{{"a", 1, 0, 0}, {"a", 1, 0, 1}, {"a", 1, 1, 0}, {"a", 2, 1, 0}, {"b", 1, 0, 0}} {
+				cache.Scope(key.Source).Put(key, img)
+			}
+			tt.clear(a, b)
+			if cache.Len() != len(tt.left) {
+				t.Errorf("got %d blocks left, want %d", cache.Len(), len(tt.left))
+			}
+			for _, key := range tt.left {
+				if _, ok := cache.Get(key); !ok {
+					t.Errorf("%+v was removed", key)
+				}
+			}
+		})
+	}
+}
+
+func TestBlockCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := NewBlockCache(2)
+	img := image.NewRGBA(image.Rect(0, 0, 1, 1))
+	cache.Put(BlockKey{BlockIndex: 0}, img)
+	cache.Put(BlockKey{BlockIndex: 1}, img)
+	cache.Get(BlockKey{BlockIndex: 0})
+	cache.Put(BlockKey{BlockIndex: 2}, img)
+	for i, want := range []bool{true, false, true} {
+		if _, ok := cache.Get(BlockKey{BlockIndex: uint(i)}); ok != want {
+			t.Errorf("block %d cached: %v, want %v", i, ok, want)
+		}
+	}
+}
+
+func TestReadRegion(t *testing.T) {
+	size := commands.CanvasSize{CanvasWidth: 10, CanvasHeight: 3 * BlockHeight}
+	tests := []struct {
+		region image.Rectangle
+		bounds image.Rectangle
+		asked  []int // Requests for each block
+	}{
+		{image.Rect(0, 0, 10, 10), image.Rect(0, 0, 10, 10), []int{1, 0, 0}},
+		{image.Rect(2, BlockHeight-1, 5, BlockHeight+1), image.Rect(2, BlockHeight-1, 5, BlockHeight+1), []int{1, 1, 0}},
+		{image.Rect(0, 2*BlockHeight, 20, 4*BlockHeight), image.Rect(0, 2*BlockHeight, 10, 3*BlockHeight), []int{0, 0, 1}}, // Clipped
+	}
+	for _, tt := range tests {
+		s := &scriptedSender{}
+		cache := NewBlockCache(10)
+		for i := 0; i < 2; i++ { // Cached the second time
+			img, err := cache.ReadRegion(s, 0, 0, size, tt.region)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if img.Rect != tt.bounds {
+				t.Errorf("%v: got bounds %v, want %v", tt.region, img.Rect, tt.bounds)
+			}
+			if blockIndex := tt.bounds.Max.Y / BlockHeight; blockIndex < 3 && img.RGBAAt(tt.bounds.Min.X, tt.bounds.Max.Y-1).R != uint8(blockIndex) {
+				t.Errorf("%v: bottom row isn't from block %d", tt.region, blockIndex)
+			}
+		}
+		for blockIndex, want := range tt.asked {
+			if got := s.asked[uint(blockIndex)]; got != want {
+				t.Errorf("%v: block %d read %d times, want %d", tt.region, blockIndex, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package preview
+
+import (
+	"image"
+	"time"
+
+	"github.com/chocolatkey/clipremote/pkg/commands"
+)
+
+type FetchOptions struct {
+	Concurrency int                       // Blocks requested at once. Also the most blocks held in memory
+	Retries     int                       // Extra attempts for a block that fails to be read
+	RetryDelay  time.Duration             // Wait before the first retry, doubled for each following one
+	Progress    func(done int, total int) // Called after each block is handed over, may be nil
+}
+
+var DefaultFetchOptions = FetchOptions{
+	Concurrency: 4,
+	Retries:     2,
+	RetryDelay:  250 * time.Millisecond,
+}
+
+type fetchResult struct {
+	img *image.RGBA
+	err error
+}
+
+func readBlockWithRetries(s Sender, galleryIdentificationNumber uint, canvasIndex uint, blockIndex uint, block image.Rectangle, opts FetchOptions) (*image.RGBA, error) {
+	delay := opts.RetryDelay
+	for attempt := 0; ; attempt++ {
+		img, err := ReadBlock(s, galleryIdentificationNumber, canvasIndex, blockIndex, block)
+		if err == nil || attempt >= opts.Retries {
+			return img, err
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// Read all blocks of a canvas, keeping up to opts.Concurrency requests in flight over the connection.
+// Blocks are handed to fn in order, whatever order they arrive in. Stops at the first error from either.
+func FetchBlocks(s Sender, galleryIdentificationNumber uint, canvasIndex uint, size commands.CanvasSize, opts FetchOptions, fn func(blockIndex uint, img *image.RGBA) error) error {
+	if opts.Concurrency < 1 {
+		opts.Concurrency = 1
+	}
+	blocks := Blocks(size)
+	results := make([]chan fetchResult, len(blocks))
+	for i := range results {
+		results[i] = make(chan fetchResult, 1)
+	}
+
+	// A slot is taken for every block requested, and given back once the block has been handed over,
+	// so blocks waiting for their turn count towards the limit too
+	slots := make(chan struct{}, opts.Concurrency)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for i, block := range blocks {
+			select {
+			case slots <- struct{}{}:
+			case <-done:
+				return
+			}
+			go func(i int, block image.Rectangle) {
+				img, err := readBlockWithRetries(s, galleryIdentificationNumber, canvasIndex, uint(i), block, opts)
+				results[i] <- fetchResult{img, err}
+			}(i, block)
+		}
+	}()
+
+	for i := range blocks {
+		result := <-results[i]
+		if result.err != nil {
+			return result.err
+		}
+		if err := fn(uint(i), result.img); err != nil {
+			return err
+		}
+		<-slots
+		if opts.Progress != nil {
+			opts.Progress(i+1, len(blocks))
+		}
+	}
+	return nil
+}
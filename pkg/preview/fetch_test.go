@@ -0,0 +1,183 @@
+package preview
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chocolatkey/clipremote"
+	"github.com/chocolatkey/clipremote/pkg/commands"
+	"github.com/chocolatkey/clipremote/pkg/packets"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Answers block requests with blocks whose pixels are the block index, after the block's
+// delay. Blocks fail until they were asked for more times than in failures.
+type scriptedSender struct {
+	delays   map[uint]time.Duration
+	failures map[uint]int
+
+	mu    sync.Mutex
+	asked map[uint]int
+}
+
+func (s *scriptedSender) SendCommandSync(command commands.Command, detail interface{}) (*packets.ServerCommand, error) {
+	req := detail.(commands.DetailPreviewWebtoonFromClientReadPreviewBlock)
+	s.mu.Lock()
+	if s.asked == nil {
+		s.asked = make(map[uint]int)
+	}
+	s.asked[req.BlockIndex]++
+	asked := s.asked[req.BlockIndex]
+	s.mu.Unlock()
+
+	time.Sleep(s.delays[req.BlockIndex])
+	if asked <= s.failures[req.BlockIndex] {
+		return nil, errors.New("connection lost")
+	}
+	return blockResponse(command, req), nil
+}
+
+// Response with the block, filled with its index.
+func blockResponse(command commands.Command, req commands.DetailPreviewWebtoonFromClientReadPreviewBlock) *packets.ServerCommand {
+	rgb := bytes.Repeat([]byte{byte(req.BlockIndex)}, int((req.BlockRight-req.BlockLeft)*(req.BlockBottom-req.BlockTop)*3))
+	return &packets.ServerCommand{
+		Type:    packets.TypeServerResponseSuccess,
+		Command: command,
+		Data:    []byte(base64.RawStdEncoding.EncodeToString(rgb)),
+	}
+}
+
+func TestFetchBlocks(t *testing.T) {
+	size := commands.CanvasSize{CanvasWidth: 4, CanvasHeight: 4*BlockHeight - 10}
+	tests := []struct {
+		name      string
+		delays    map[uint]time.Duration
+		failures  map[uint]int
+		retries   int
+		stopAfter int // Blocks fn takes before failing, -1 for all
+		got       int // Blocks handed to fn
+		err       bool
+	}{
+		{"in order", nil, nil, 0, -1, 4, false},
+		{"arriving in reverse", map[uint]time.Duration{0: 30 * time.Millisecond, 1: 20 * time.Millisecond, 2: 10 * time.Millisecond}, nil, 0, -1, 4, false},
+		{"retried", nil, map[uint]int{1: 2, 3: 1}, 2, -1, 4, false},
+		{"out of retries", nil, map[uint]int{2: 3}, 2, -1, 2, true},
+		{"fn fails", nil, nil, 0, 1, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &scriptedSender{delays: tt.delays, failures: tt.failures}
+			opts := FetchOptions{Concurrency: 4, Retries: tt.retries, RetryDelay: time.Millisecond}
+			var got []uint
+			err := FetchBlocks(s, 0, 0, size, opts, func(blockIndex uint, img *image.RGBA) error {
+				if len(got) == tt.stopAfter {
+					return errors.New("stop")
+				}
+				if want := Blocks(size)[blockIndex]; img.Rect != want {
+					t.Errorf("block %d: got bounds %v, want %v", blockIndex, img.Rect, want)
+				}
+				if img.Pix[0] != byte(blockIndex) {
+					t.Errorf("block %d: got block %d's pixels", blockIndex, img.Pix[0])
+				}
+				got = append(got, blockIndex)
+				return nil
+			})
+			if (err != nil) != tt.err {
+				t.Errorf("got error %v", err)
+			}
+			if len(got) != tt.got {
+				t.Fatalf("got %d blocks, want %d", len(got), tt.got)
+			}
+			for i, blockIndex := range got {
+				if blockIndex != uint(i) {
+					t.Fatalf("got blocks %v out of order", got)
+				}
+			}
+		})
+	}
+}
+
+// Fake CSP answering every command after the latency, with block requests getting a block.
+// Commands are answered in parallel, the way pipelined ones are.
+func fakeCSP(b *testing.B, latency time.Duration) uint16 {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				var writeMu sync.Mutex
+				r := bufio.NewReader(conn)
+				for {
+					data, err := r.ReadBytes(0)
+					if err != nil {
+						return
+					}
+					frags := bytes.Split(data[2:len(data)-2], []byte{0x1e, '$'})
+					command := commands.Command(frags[1][len("command="):])
+					serial := string(frags[2][len("serial="):])
+					var req commands.DetailPreviewWebtoonFromClientReadPreviewBlock
+					json.Unmarshal(frags[3][len("detail="):], &req)
+					go func() {
+						time.Sleep(latency)
+						detail := `{"pad":"xxxxxxxxxxxxxxxxxxxxxxxxxxxxxx"}`
+						if req.Operation == "ReadPreviewBlock" {
+							detail += "\x0b" + string(blockResponse(command, req).Data)
+						}
+						writeMu.Lock()
+						defer writeMu.Unlock()
+						fmt.Fprintf(conn, "\x06$tcp_remote_command_protocol_version=1.0\x1e$command=%s\x1e$serial=%s\x1e$detail=%s\x1e\x00", command, serial, detail)
+					}()
+				}
+			}()
+		}
+	}()
+	return uint16(ln.Addr().(*net.TCPAddr).Port)
+}
+
+func BenchmarkFetchBlocks(b *testing.B) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+	client, err := clipremote.New(context.Background(), []string{"127.0.0.1"}, fakeCSP(b, 20*time.Millisecond), "gen",
+		clipremote.WithPassword("password"), clipremote.WithLogger(logger))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer client.Close()
+
+	size := commands.CanvasSize{CanvasWidth: 128, CanvasHeight: 16 * BlockHeight}
+	for _, concurrency := range []int{1, 2, 4, 8, 16} {
+		b.Run("concurrency="+strconv.Itoa(concurrency), func(b *testing.B) {
+			opts := DefaultFetchOptions
+			opts.Concurrency = concurrency
+			started := time.Now()
+			for i := 0; i < b.N; i++ {
+				err := FetchBlocks(client, 0, 0, size, opts, func(uint, *image.RGBA) error {
+					return nil
+				})
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(b.N*len(Blocks(size)))/time.Since(started).Seconds(), "blocks/s")
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package preview
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"image"
+	"image/draw"
+
+	"github.com/chocolatkey/clipremote/pkg/commands"
+	"github.com/chocolatkey/clipremote/pkg/packets"
+	"github.com/pkg/errors"
+)
+
+// Height of the blocks canvases are read in. Matches what the official app requests.
+const BlockHeight = 1024
+
+// Sender is the part of the client needed to request previews.
+type Sender interface {
+	SendCommandSync(command commands.Command, detail interface{}) (*packets.ServerCommand, error)
+}
+
+// Ask the server to (re)build the webtoon gallery, and return the sizes of the canvases in it.
+func UpdateGallery(s Sender, maxLength uint) (*commands.DetailPreviewWebtoonFromClientResponseUpdateGallery, error) {
+	scp, err := s.SendCommandSync(
+		commands.PreviewWebtoonFromClient,
+		commands.DetailPreviewWebtoonFromClientRequestUpdateGallery{
+			Operation: "UpdateGallery",
+			MaxLength: maxLength,
+		},
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed updating gallery")
+	}
+	if err = scp.Err(); err != nil {
+		return nil, errors.Wrap(err, "server refused to update gallery")
+	}
+
+	// Detail is decoded generically, so round-trip it into the typed struct
+	bin, err := json.Marshal(scp.Detail)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed re-encoding gallery detail")
+	}
+	var gallery commands.DetailPreviewWebtoonFromClientResponseUpdateGallery
+	if err = json.Unmarshal(bin, &gallery); err != nil {
+		return nil, errors.Wrap(err, "failed decoding gallery detail")
+	}
+	return &gallery, nil
+}
+
+// Blocks needed to read a canvas of the given size, top to bottom.
+func Blocks(size commands.CanvasSize) []image.Rectangle {
+	blocks := make([]image.Rectangle, 0, (size.CanvasHeight+BlockHeight-1)/BlockHeight)
+	for top := 0; top < int(size.CanvasHeight); top += BlockHeight {
+		bottom := top + BlockHeight
+		if bottom > int(size.CanvasHeight) {
+			bottom = int(size.CanvasHeight)
+		}
+		blocks = append(blocks, image.Rect(0, top, int(size.CanvasWidth), bottom))
+	}
+	return blocks
+}
+
+// Read a single block of a canvas. The returned image has the same bounds as the block.
+func ReadBlock(s Sender, galleryIdentificationNumber uint, canvasIndex uint, blockIndex uint, block image.Rectangle) (*image.RGBA, error) {
+	scp, err := s.SendCommandSync(
+		commands.PreviewWebtoonFromClient,
+		commands.DetailPreviewWebtoonFromClientReadPreviewBlock{
+			Operation:                   "ReadPreviewBlock",
+			BlockIndex:                  blockIndex,
+			BlockBottom:                 uint(block.Max.Y),
+			BlockRight:                  uint(block.Max.X),
+			BlockTop:                    uint(block.Min.Y),
+			BlockLeft:                   uint(block.Min.X),
+			CanvasIndex:                 canvasIndex,
+			GalleryIdentificationNumber: galleryIdentificationNumber,
+		},
+	)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed reading preview block %d", blockIndex)
+	}
+	if err = scp.Err(); err != nil {
+		return nil, errors.Wrapf(err, "server refused to read preview block %d", blockIndex)
+	}
+	if len(scp.Data) == 0 {
+		return nil, errors.Errorf("preview block %d has no image data", blockIndex)
+	}
+
+	rgbData, err := base64.RawStdEncoding.DecodeString(string(scp.Data))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed decoding preview block %d", blockIndex)
+	}
+	if len(rgbData) < block.Dx()*block.Dy()*3 {
+		return nil, errors.Errorf("preview block %d has %d bytes of image data, expected %d", blockIndex, len(rgbData), block.Dx()*block.Dy()*3)
+	}
+
+	img := Decode(rgbData, block.Dx(), block.Dy())
+	img.Rect = block
+	return img, nil
+}
+
+// Read a whole canvas from the gallery, stitching its blocks together.
+func ReadCanvas(s Sender, galleryIdentificationNumber uint, canvasIndex uint, size commands.CanvasSize, opts FetchOptions) (*image.RGBA, error) {
+	canvas := image.NewRGBA(image.Rect(0, 0, int(size.CanvasWidth), int(size.CanvasHeight)))
+	err := FetchBlocks(s, galleryIdentificationNumber, canvasIndex, size, opts, func(_ uint, img *image.RGBA) error {
+		draw.Draw(canvas, img.Rect, img, img.Rect.Min, draw.Src)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return canvas, nil
+}
@@ -0,0 +1,194 @@
+package preview
+
+import (
+	"bufio"
+	"compress/zlib"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"io"
+	"math"
+
+	"github.com/chocolatkey/clipremote/pkg/commands"
+	"github.com/pkg/errors"
+)
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// Largest IDAT chunk written. Data is buffered up to this size.
+const pngChunkSize = 1 << 16
+
+// PNGWriter encodes an 8-bit RGB PNG row by row, so images of any height can be written
+// without ever holding more than a couple of rows in memory (unlike image/png).
+type PNGWriter struct {
+	w      io.Writer
+	width  int
+	height int
+	y      int // Rows written so far
+	idat   *bufio.Writer
+	zw     *zlib.Writer
+	prev   []byte // Previous row, unfiltered
+	cur    []byte // Current row, unfiltered
+	out    []byte // Current row, filtered, with filter type byte
+}
+
+func writeChunk(w io.Writer, kind string, data []byte) error {
+	header := make([]byte, 8)
+	binary.BigEndian.PutUint32(header[:4], uint32(len(data)))
+	copy(header[4:], kind)
+	crc := crc32.NewIEEE()
+	crc.Write(header[4:])
+	crc.Write(data)
+	footer := make([]byte, 4)
+	binary.BigEndian.PutUint32(footer, crc.Sum32())
+
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	_, err := w.Write(footer)
+	return err
+}
+
+// Splits whatever is written to it into IDAT chunks.
+type idatWriter struct {
+	w io.Writer
+}
+
+func (iw idatWriter) Write(p []byte) (int, error) {
+	if err := writeChunk(iw.w, "IDAT", p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Start a PNG of the given size, writing its header right away.
+func NewPNGWriter(w io.Writer, width int, height int) (*PNGWriter, error) {
+	if width <= 0 || height <= 0 {
+		return nil, errors.New("invalid PNG size")
+	}
+	if _, err := w.Write(pngSignature); err != nil {
+		return nil, errors.Wrap(err, "failed writing PNG signature")
+	}
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:4], uint32(width))
+	binary.BigEndian.PutUint32(ihdr[4:8], uint32(height))
+	ihdr[8] = 8 // Bit depth
+	ihdr[9] = 2 // Color type: truecolor
+	// Compression, filter and interlace methods are all 0
+	if err := writeChunk(w, "IHDR", ihdr); err != nil {
+		return nil, errors.Wrap(err, "failed writing PNG header")
+	}
+
+	idat := bufio.NewWriterSize(idatWriter{w}, pngChunkSize)
+	return &PNGWriter{
+		w:      w,
+		width:  width,
+		height: height,
+		idat:   idat,
+		zw:     zlib.NewWriter(idat),
+		prev:   make([]byte, width*3),
+		cur:    make([]byte, width*3),
+		out:    make([]byte, 1+width*3),
+	}, nil
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func paeth(a, b, c byte) byte {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := abs(p-int(a)), abs(p-int(b)), abs(p-int(c))
+	if pa <= pb && pa <= pc {
+		return a
+	} else if pb <= pc {
+		return b
+	}
+	return c
+}
+
+// Filter the current row with the Paeth filter, which suits artwork well, and compress it.
+func (p *PNGWriter) flushRow() error {
+	p.out[0] = 4 // Paeth
+	for i := 0; i < len(p.cur); i++ {
+		var a, c byte
+		if i >= 3 {
+			a = p.cur[i-3]
+			c = p.prev[i-3]
+		}
+		p.out[i+1] = p.cur[i] - paeth(a, p.prev[i], c)
+	}
+	if _, err := p.zw.Write(p.out); err != nil {
+		return errors.Wrap(err, "failed compressing PNG row")
+	}
+	p.prev, p.cur = p.cur, p.prev
+	p.y++
+	return nil
+}
+
+// Write the rows of the image, which must be as wide as the PNG. Alpha is dropped.
+func (p *PNGWriter) WriteRows(img *image.RGBA) error {
+	b := img.Bounds()
+	if b.Dx() != p.width {
+		return errors.Errorf("rows are %d pixels wide, expected %d", b.Dx(), p.width)
+	}
+	if p.y+b.Dy() > p.height {
+		return errors.New("too many rows written to PNG")
+	}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		i := img.PixOffset(b.Min.X, y)
+		for x := 0; x < p.width; x++ {
+			p.cur[x*3] = img.Pix[i]
+			p.cur[x*3+1] = img.Pix[i+1]
+			p.cur[x*3+2] = img.Pix[i+2]
+			i += 4
+		}
+		if err := p.flushRow(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Finish the PNG. All rows must have been written.
+func (p *PNGWriter) Close() error {
+	if p.y != p.height {
+		return errors.Errorf("only %d of %d PNG rows were written", p.y, p.height)
+	}
+	if err := p.zw.Close(); err != nil {
+		return errors.Wrap(err, "failed finishing PNG data")
+	}
+	if err := p.idat.Flush(); err != nil {
+		return errors.Wrap(err, "failed writing PNG data")
+	}
+	return errors.Wrap(writeChunk(p.w, "IEND", nil), "failed writing PNG end")
+}
+
+// Stream a whole canvas as PNG, reading its blocks in order.
+// Only opts.Concurrency blocks are held in memory at a time, however tall the canvas.
+// Nothing is written until the first block is read, so if that fails w is left untouched.
+func StreamCanvas(w io.Writer, s Sender, galleryIdentificationNumber uint, canvasIndex uint, size commands.CanvasSize, opts FetchOptions) error {
+	if size.CanvasWidth == 0 || size.CanvasHeight == 0 || size.CanvasWidth > math.MaxInt32 || size.CanvasHeight > math.MaxInt32 {
+		return errors.Errorf("canvas size %dx%d can't be a PNG", size.CanvasWidth, size.CanvasHeight)
+	}
+	var pw *PNGWriter
+	err := FetchBlocks(s, galleryIdentificationNumber, canvasIndex, size, opts, func(_ uint, img *image.RGBA) error {
+		if pw == nil {
+			var err error
+			if pw, err = NewPNGWriter(w, int(size.CanvasWidth), int(size.CanvasHeight)); err != nil {
+				return err
+			}
+		}
+		return pw.WriteRows(img)
+	})
+	if err != nil {
+		return err
+	}
+	return pw.Close()
+}
@@ -0,0 +1,54 @@
+package preview
+
+import (
+	"bytes"
+	"image/png"
+	"testing"
+
+	"github.com/chocolatkey/clipremote/pkg/commands"
+)
+
+func TestStreamCanvas(t *testing.T) {
+	tests := []struct {
+		name     string
+		size     commands.CanvasSize
+		failures map[uint]int
+		err      bool
+		written  bool
+	}{
+		{"one block", commands.CanvasSize{CanvasWidth: 3, CanvasHeight: 10}, nil, false, true},
+		{"several blocks", commands.CanvasSize{CanvasWidth: 3, CanvasHeight: 2*BlockHeight + 5}, nil, false, true},
+		{"first block fails", commands.CanvasSize{CanvasWidth: 3, CanvasHeight: 2 * BlockHeight}, map[uint]int{0: 1}, true, false},
+		{"later block fails", commands.CanvasSize{CanvasWidth: 3, CanvasHeight: 2 * BlockHeight}, map[uint]int{1: 1}, true, true},
+		{"empty", commands.CanvasSize{CanvasWidth: 0, CanvasHeight: 10}, nil, true, false},
+		{"too wide", commands.CanvasSize{CanvasWidth: 1 << 31, CanvasHeight: 10}, nil, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			s := &scriptedSender{failures: tt.failures}
+			err := StreamCanvas(&buf, s, 0, 0, tt.size, FetchOptions{Concurrency: 2})
+			if (err != nil) != tt.err {
+				t.Fatalf("got error %v", err)
+			}
+			if written := buf.Len() > 0; written != tt.written {
+				t.Fatalf("written: %v, want %v", written, tt.written)
+			}
+			if tt.err {
+				return
+			}
+			img, err := png.Decode(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if b := img.Bounds(); b.Dx() != int(tt.size.CanvasWidth) || b.Dy() != int(tt.size.CanvasHeight) {
+				t.Fatalf("got size %v", b)
+			}
+			for i, block := range Blocks(tt.size) {
+				if r, _, _, _ := img.At(0, block.Max.Y-1).RGBA(); r>>8 != uint32(i) {
+					t.Errorf("block %d: got pixels of block %d", i, r>>8)
+				}
+			}
+		})
+	}
+}
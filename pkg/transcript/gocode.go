@@ -0,0 +1,201 @@
+package transcript
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/format"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/chocolatkey/clipremote/pkg/commands"
+	"github.com/pkg/errors"
+)
+
+// Examples longer than this many lines are left out of the generated code.
+const maxExampleLines = 40
+
+type generator struct {
+	structs map[string]string // Definitions of the types generated so far, by name
+	nested  []string          // Definitions of nested types, written after the detail using them
+}
+
+// Go type for a shape. Objects become named structs, with the name given.
+func (g *generator) goType(s *Shape, name string) string {
+	kinds := 0
+	for _, n := range []int{s.Bools, s.Integers + s.Numbers, s.Strings, s.Arrays, s.Objects} {
+		if n > 0 {
+			kinds++
+		}
+	}
+	if kinds != 1 {
+		return "interface{}"
+	}
+
+	var t string
+	switch {
+	case s.Bools > 0:
+		t = "bool"
+	case s.Numbers > 0:
+		t = "float64"
+	case s.Integers > 0:
+		t = "uint"
+		if s.Negative {
+			t = "int"
+		}
+	case s.Strings > 0:
+		t = "string"
+	case s.Arrays > 0:
+		if s.Items == nil {
+			return "[]interface{}"
+		}
+		return "[]" + g.goType(s.Items, name)
+	case s.Objects > 0:
+		t = g.structType(s, name)
+	}
+	if s.Nulls > 0 {
+		t = "*" + t
+	}
+	return t
+}
+
+// Comment for a field, with the values it can have and whether it's always there.
+func fieldComment(parent *Shape, name string) string {
+	var notes []string
+	if enum := parent.Fields[name].Enum(); enum != nil {
+		for i, value := range enum {
+			enum[i] = strconv.Quote(value)
+		}
+		notes = append(notes, strings.Join(enum, ", "))
+	}
+	if parent.Optional(name) {
+		notes = append(notes, "not always sent")
+	}
+	if len(notes) == 0 {
+		return ""
+	}
+	return " // " + strings.Join(notes, "; ")
+}
+
+func (g *generator) structBody(s *Shape, name string) string {
+	var b strings.Builder
+	b.WriteString("struct {\n")
+	for _, field := range s.FieldNames() {
+		// Nested objects are named after their field, like CanvasSizeArray's CanvasSize
+		nestedName := strings.TrimSuffix(field, "Array")
+		fmt.Fprintf(&b, "\t%s %s%s\n", field, g.goType(s.Fields[field], nestedName), fieldComment(s, field))
+	}
+	b.WriteString("}")
+	return b.String()
+}
+
+// Name of the struct type for an object shape, generating it if it's new.
+func (g *generator) structType(s *Shape, name string) string {
+	body := g.structBody(s, name)
+	for i := 1; ; i++ {
+		candidate := name
+		if i > 1 {
+			candidate += strconv.Itoa(i)
+		}
+		existing, ok := g.structs[candidate]
+		if existing == body {
+			return candidate
+		}
+		if !ok {
+			g.structs[candidate] = body
+			g.nested = append(g.nested, "type "+candidate+" "+body+"\n")
+			return candidate
+		}
+	}
+}
+
+func example(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	bin, err := json.MarshalIndent(v, "\t", "\t")
+	if err != nil || bytes.Count(bin, []byte("\n")) >= maxExampleLines {
+		return ""
+	}
+	return "/*\nExample:\n\n\t" + string(bin) + "\n*/\n"
+}
+
+// Write Go definitions for the details in the style of pkg/commands: constants for commands that
+// aren't known yet, then a struct for each detail with an example.
+func GenerateGo(w io.Writer, details []*Detail) error {
+	g := &generator{structs: make(map[string]string)}
+	var b bytes.Buffer
+	b.WriteString("// Inferred from traffic. Check the types and describe the commands before adding them to pkg/commands/commands.yaml.\n\n")
+	b.WriteString("package commands\n\n")
+
+	var unknown []commands.Command
+	for i, d := range details {
+		if (i == 0 || details[i-1].Command != d.Command) && !isKnown(d.Command) {
+			unknown = append(unknown, d.Command)
+		}
+	}
+	if len(unknown) > 0 {
+		b.WriteString("const (\n")
+		for _, command := range unknown {
+			fmt.Fprintf(&b, "\t%s Command = %q // TODO\n", command, command)
+		}
+		b.WriteString(")\n\n")
+	}
+
+	for i, d := range details {
+		if i == 0 || details[i-1].Command != d.Command {
+			fmt.Fprintf(&b, "// Command%s //\n\n", d.Command)
+		}
+		kind := strings.ToLower(string(d.Kind))
+		switch {
+		case d.Shape.Count == 0:
+			fmt.Fprintf(&b, "// Every %s was an error (%s)\n\n", kind, times(d.Errors))
+			continue
+		case d.Shape.Nulls == d.Shape.Count:
+			fmt.Fprintf(&b, "// No detail in the %s\n\n", kind)
+			continue
+		}
+		fmt.Fprintf(&b, "// Seen %s", times(d.Shape.Count))
+		if d.Errors > 0 {
+			fmt.Fprintf(&b, ", and errors %s", times(d.Errors))
+		}
+		b.WriteString("\n")
+		b.WriteString(example(d.Example))
+		name := d.TypeName()
+		if d.Shape.Objects > 0 {
+			body := g.structBody(d.Shape, name)
+			g.structs[name] = body
+			fmt.Fprintf(&b, "type %s %s\n\n", name, body)
+		} else {
+			fmt.Fprintf(&b, "type %s %s\n\n", name, g.goType(d.Shape, name))
+		}
+		for _, nested := range g.nested {
+			b.WriteString(nested + "\n")
+		}
+		g.nested = nil
+	}
+
+	src, err := format.Source(b.Bytes())
+	if err != nil {
+		return errors.Wrap(err, "generated invalid Go")
+	}
+	_, err = w.Write(src)
+	return err
+}
+
+func times(n int) string {
+	if n == 1 {
+		return "once"
+	}
+	return strconv.Itoa(n) + " times"
+}
+
+func isKnown(command commands.Command) bool {
+	for _, spec := range commands.Registry {
+		if spec.Command == command {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,310 @@
+package transcript
+
+import (
+	"math"
+	"sort"
+
+	"github.com/chocolatkey/clipremote/pkg/commands"
+	"github.com/chocolatkey/clipremote/pkg/packets"
+	"github.com/pkg/errors"
+)
+
+// Strings with at most this many different values, none of them longer than maxEnumLength,
+// are taken to be enums, like ServerSelectedTabKind. Only once they've been seen at least
+// minEnumSamples times and some values came up more than once, since a few samples of any
+// string would otherwise look like an enum too.
+const (
+	maxEnumValues  = 10
+	maxEnumLength  = 64
+	minEnumSamples = 3
+)
+
+// Shape is what's been seen of a JSON value across samples.
+type Shape struct {
+	Count    int // Values seen
+	Nulls    int
+	Bools    int
+	Integers int
+	Numbers  int // Numbers that aren't integers
+	Strings  int
+	Arrays   int
+	Objects  int
+	Negative bool           // Some number was negative
+	Values   map[string]int // Different strings seen. Nil once there are too many for an enum
+	Fields   map[string]*Shape
+	Items    *Shape // Items of arrays, nil if they were all empty
+}
+
+func (s *Shape) Add(v interface{}) {
+	s.Count++
+	switch v := v.(type) {
+	case nil:
+		s.Nulls++
+	case bool:
+		s.Bools++
+	case float64:
+		if v == math.Trunc(v) {
+			s.Integers++
+		} else {
+			s.Numbers++
+		}
+		if v < 0 {
+			s.Negative = true
+		}
+	case string:
+		s.Strings++
+		if s.Strings == 1 {
+			s.Values = make(map[string]int)
+		}
+		if s.Values != nil {
+			s.Values[v]++
+			if len(v) > maxEnumLength || len(s.Values) > maxEnumValues {
+				s.Values = nil
+			}
+		}
+	case []interface{}:
+		s.Arrays++
+		for _, item := range v {
+			if s.Items == nil {
+				s.Items = &Shape{}
+			}
+			s.Items.Add(item)
+		}
+	case map[string]interface{}:
+		s.Objects++
+		if s.Fields == nil {
+			s.Fields = make(map[string]*Shape)
+		}
+		for name, value := range v {
+			field, ok := s.Fields[name]
+			if !ok {
+				field = &Shape{}
+				s.Fields[name] = field
+			}
+			field.Add(value)
+		}
+	}
+}
+
+// Whether a field of an object shape was missing from some of the objects.
+func (s *Shape) Optional(name string) bool {
+	field, ok := s.Fields[name]
+	return !ok || field.Count < s.Objects
+}
+
+// Names of the fields of an object shape, with Operation first like in pkg/commands.
+func (s *Shape) FieldNames() []string {
+	names := make([]string, 0, len(s.Fields))
+	for name := range s.Fields {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if (names[i] == "Operation") != (names[j] == "Operation") {
+			return names[i] == "Operation"
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
+
+// Enum values, sorted, if the strings look like an enum.
+func (s *Shape) Enum() []string {
+	if s.Values == nil || s.Strings < minEnumSamples || len(s.Values) == s.Strings {
+		return nil
+	}
+	values := make([]string, 0, len(s.Values))
+	for value := range s.Values {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+	return values
+}
+
+func (s *Shape) types() []string {
+	var types []string
+	if s.Nulls > 0 {
+		types = append(types, "null")
+	}
+	if s.Bools > 0 {
+		types = append(types, "boolean")
+	}
+	if s.Numbers > 0 {
+		types = append(types, "number")
+	} else if s.Integers > 0 {
+		types = append(types, "integer")
+	}
+	if s.Strings > 0 {
+		types = append(types, "string")
+	}
+	if s.Arrays > 0 {
+		types = append(types, "array")
+	}
+	if s.Objects > 0 {
+		types = append(types, "object")
+	}
+	return types
+}
+
+// JSON Schema of what's been seen, in the same form as commands.SchemaOf.
+func (s *Shape) Schema() commands.Schema {
+	schema := commands.Schema{}
+	switch types := s.types(); len(types) {
+	case 0:
+		return schema // Anything
+	case 1:
+		schema["type"] = types[0]
+	default:
+		schema["type"] = types
+	}
+	if s.Integers > 0 && s.Numbers == 0 && !s.Negative {
+		schema["minimum"] = 0
+	}
+	if enum := s.Enum(); enum != nil {
+		schema["enum"] = enum
+	}
+	if s.Arrays > 0 {
+		if s.Items != nil {
+			schema["items"] = s.Items.Schema()
+		} else {
+			schema["items"] = commands.Schema{}
+		}
+	}
+	if s.Objects > 0 {
+		properties := make(map[string]interface{})
+		required := make([]string, 0, len(s.Fields))
+		for _, name := range s.FieldNames() {
+			properties[name] = s.Fields[name].Schema()
+			if !s.Optional(name) {
+				required = append(required, name)
+			}
+		}
+		schema["properties"] = properties
+		schema["required"] = required
+	}
+	return schema
+}
+
+// Direction of a detail.
+type Kind string
+
+const (
+	KindRequest  Kind = "Request"  // Sent by the client
+	KindResponse Kind = "Response" // Sent by CSP, in response or on its own
+)
+
+// Detail is the inferred shape of one command's or operation's detail in one direction.
+type Detail struct {
+	Command   commands.Command
+	Operation string
+	Kind      Kind
+	Shape     *Shape
+	Example   interface{} // First detail seen
+	Errors    int         // Error responses, which aren't part of the shape
+}
+
+// Name like "Command" or "Command/Operation", see commands.Spec.Name.
+func (d *Detail) Name() string {
+	if d.Operation == "" {
+		return string(d.Command)
+	}
+	return string(d.Command) + "/" + d.Operation
+}
+
+// Name of the Go type for the detail, in the pkg/commands style like
+// DetailPreviewWebtoonFromClientRequestUpdateGallery.
+func (d *Detail) TypeName() string {
+	return "Detail" + string(d.Command) + string(d.Kind) + d.Operation
+}
+
+type detailKey struct {
+	name string
+	kind Kind
+}
+
+// Inferrer builds up the shapes of details from transcript entries.
+type Inferrer struct {
+	details map[detailKey]*Detail
+	pending map[packets.Serial]string // Operation of commands waiting for a response
+	Packets int
+	Skipped int // Packets that couldn't be parsed
+}
+
+func NewInferrer() *Inferrer {
+	return &Inferrer{
+		details: make(map[detailKey]*Detail),
+		pending: make(map[packets.Serial]string),
+	}
+}
+
+func (in *Inferrer) detail(command commands.Command, operation string, kind Kind) *Detail {
+	key := detailKey{string(command) + "/" + operation, kind}
+	d, ok := in.details[key]
+	if !ok {
+		d = &Detail{Command: command, Operation: operation, Kind: kind, Shape: &Shape{}}
+		in.details[key] = d
+	}
+	return d
+}
+
+// Add a packet from a transcript. Authentication is skipped, since its detail is secret.
+func (in *Inferrer) Add(entry Entry) error {
+	in.Packets++
+	var pkt packets.ServerCommand // Parses what the client sends just as well
+	if err := pkt.Parse(entry.Packet); err != nil {
+		in.Skipped++
+		return errors.Wrap(err, "failed parsing packet")
+	}
+	if pkt.Command == commands.Authenticate {
+		return nil
+	}
+
+	if pkt.Serial == 0 && (entry.Outgoing || pkt.Type == packets.TypeClientCommand) {
+		// Serials restart after the client reconnects or CSP resets, and commands still
+		// waiting won't get a response
+		in.pending = make(map[packets.Serial]string)
+	}
+
+	var d *Detail
+	switch {
+	case entry.Outgoing:
+		operation := commands.OperationOf(pkt.Detail)
+		in.pending[pkt.Serial] = operation
+		d = in.detail(pkt.Command, operation, KindRequest)
+	case pkt.Type == packets.TypeClientCommand:
+		d = in.detail(pkt.Command, commands.OperationOf(pkt.Detail), KindResponse)
+	default:
+		// Responses don't always repeat the operation, the request has it
+		operation, ok := in.pending[pkt.Serial]
+		if !ok {
+			operation = commands.OperationOf(pkt.Detail)
+		}
+		delete(in.pending, pkt.Serial)
+		d = in.detail(pkt.Command, operation, KindResponse)
+		if pkt.Type == packets.TypeServerResponseError {
+			d.Errors++
+			return nil
+		}
+	}
+	if d.Shape.Count == 0 {
+		d.Example = pkt.Detail
+	}
+	d.Shape.Add(pkt.Detail)
+	return nil
+}
+
+// All details seen, sorted by name, requests first.
+func (in *Inferrer) Details() []*Detail {
+	details := make([]*Detail, 0, len(in.details))
+	for _, d := range in.details {
+		if d.Shape.Count > 0 || d.Errors > 0 {
+			details = append(details, d)
+		}
+	}
+	sort.Slice(details, func(i, j int) bool {
+		if details[i].Name() != details[j].Name() {
+			return details[i].Name() < details[j].Name()
+		}
+		return details[i].Kind < details[j].Kind
+	})
+	return details
+}
@@ -0,0 +1,109 @@
+package transcript
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/chocolatkey/clipremote/pkg/commands"
+	"github.com/chocolatkey/clipremote/pkg/packets"
+)
+
+func TestShapeEnum(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []interface{}
+		want   []string
+	}{
+		{"too few samples", []interface{}{"a", "a"}, nil},
+		{"repeating", []interface{}{"b", "a", "b"}, []string{"a", "b"}},
+		{"all different", []interface{}{"a", "b", "c", "d"}, nil},
+		{"too many values", []interface{}{"0", "1", "2", "3", "4", "5", "6", "7", "8", "9", "10", "0"}, nil},
+		{"too long", []interface{}{"a", "a", string(make([]byte, maxEnumLength+1))}, nil},
+		{"not strings", []interface{}{1.0, 1.0, 1.0}, nil},
+	}
+	for _, tt := range tests {
+		var s Shape
+		for _, v := range tt.values {
+			s.Add(v)
+		}
+		if got := s.Enum(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestShapeSchema(t *testing.T) {
+	var s Shape
+	s.Add(map[string]interface{}{"Operation": "Read", "Index": 1.0, "Items": []interface{}{"x"}})
+	s.Add(map[string]interface{}{"Operation": "Read", "Index": 2.0, "Offset": -1.5})
+	want := commands.Schema{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"Operation": commands.Schema{"type": "string"},
+			"Index":     commands.Schema{"type": "integer", "minimum": 0},
+			"Items":     commands.Schema{"type": "array", "items": commands.Schema{"type": "string"}},
+			"Offset":    commands.Schema{"type": "number"},
+		},
+		"required": []string{"Operation", "Index"},
+	}
+	if got := s.Schema(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+}
+
+// Raw packet of the given type.
+func packet(t *testing.T, typ packets.PacketType, command commands.Command, serial packets.Serial, detail interface{}) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := (packets.ClientCommand{Command: command, Serial: serial, Detail: detail}).Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	data[0] = byte(typ)
+	return data
+}
+
+func TestInferrerResponseOperations(t *testing.T) {
+	const command = commands.PreviewWebtoonFromClient
+	read := map[string]interface{}{"Operation": "ReadPreviewBlock"}
+	response := map[string]interface{}{"Data": "x"}
+	tests := []struct {
+		name    string
+		entries []Entry
+		want    []string // Names of the response details
+	}{
+		{"operation from the request", []Entry{
+			{Outgoing: true, Packet: packet(t, packets.TypeClientCommand, command, 1, read)},
+			{Packet: packet(t, packets.TypeServerResponseSuccess, command, 1, response)},
+		}, []string{"PreviewWebtoonFromClient/ReadPreviewBlock"}},
+		{"client restarted serials", []Entry{
+			{Outgoing: true, Packet: packet(t, packets.TypeClientCommand, command, 1, read)},
+			{Outgoing: true, Packet: packet(t, packets.TypeClientCommand, commands.GetServerSelectedTabKind, 0, nil)},
+			{Outgoing: true, Packet: packet(t, packets.TypeClientCommand, commands.GetServerSelectedTabKind, 1, nil)},
+			{Packet: packet(t, packets.TypeServerResponseSuccess, command, 1, response)},
+		}, []string{"PreviewWebtoonFromClient"}},
+		{"server reset", []Entry{
+			{Outgoing: true, Packet: packet(t, packets.TypeClientCommand, command, 1, read)},
+			{Packet: packet(t, packets.TypeClientCommand, "PreviewWebtoonFromServer", 0, map[string]interface{}{"Operation": "ResetCanvas"})},
+			{Packet: packet(t, packets.TypeServerResponseSuccess, command, 1, response)},
+		}, []string{"PreviewWebtoonFromClient", "PreviewWebtoonFromServer/ResetCanvas"}},
+	}
+	for _, tt := range tests {
+		in := NewInferrer()
+		for _, entry := range tt.entries {
+			if err := in.Add(entry); err != nil {
+				t.Fatal(err)
+			}
+		}
+		var got []string
+		for _, d := range in.Details() {
+			if d.Kind == KindResponse {
+				got = append(got, d.Name())
+			}
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
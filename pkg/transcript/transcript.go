@@ -0,0 +1,73 @@
+// Package transcript records the packets going over a connection, and infers the shape of
+// command details from recordings, to help document commands that aren't known yet.
+package transcript
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Entry is one packet in a transcript. Transcripts are written as one entry per line.
+type Entry struct {
+	Time     time.Time `json:"time"`
+	Outgoing bool      `json:"outgoing"` // Sent by the client
+	Packet   []byte    `json:"packet"`   // Raw packet, including the terminator
+}
+
+// Writes every packet to a transcript, except for authentication.
+type Recorder struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	err error
+}
+
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{enc: json.NewEncoder(w)}
+}
+
+// Record a packet. Meant to be used as the client's wire tap.
+func (r *Recorder) Tap(outgoing bool, data []byte) {
+	if bytes.Contains(data, []byte("command=Authenticate")) {
+		return // Has secrets in it, and nothing left to learn
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err == nil {
+		r.err = r.enc.Encode(Entry{
+			Time:     time.Now(),
+			Outgoing: outgoing,
+			Packet:   append([]byte(nil), data...),
+		})
+	}
+}
+
+// First error writing the transcript, if any.
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// Read all entries of a transcript.
+func Read(r io.Reader) ([]Entry, error) {
+	var entries []Entry
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024) // Preview blocks are big
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, errors.Wrapf(err, "invalid transcript entry on line %d", lineNumber)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, errors.Wrap(scanner.Err(), "failed reading transcript")
+}
@@ -0,0 +1,31 @@
+package webtoon
+
+import (
+	"fmt"
+
+	"github.com/chocolatkey/clipremote/pkg/preview"
+	"github.com/pkg/errors"
+)
+
+// Read every canvas in the gallery and write it out as platform-ready strips.
+// Each canvas gets its own prefix, so the files of an episode sort in reading order.
+func ExportGallery(s preview.Sender, maxLength uint, preset Preset, dir string, opts preview.FetchOptions) ([]string, error) {
+	gallery, err := preview.UpdateGallery(s, maxLength)
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for i, size := range gallery.CanvasSizeArray {
+		canvas, err := preview.ReadCanvas(s, gallery.GalleryIdentificationNumber, uint(i), size, opts)
+		if err != nil {
+			return paths, errors.Wrapf(err, "failed reading canvas %d", i)
+		}
+		written, err := WriteStrips(dir, fmt.Sprintf("%02d_", i+1), SlicePreset(canvas, preset), preset.Format, preset.Quality)
+		paths = append(paths, written...)
+		if err != nil {
+			return paths, err
+		}
+	}
+	return paths, nil
+}
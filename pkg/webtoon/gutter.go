@@ -0,0 +1,117 @@
+package webtoon
+
+import (
+	"image"
+	"image/color"
+)
+
+// Largest per-channel difference for two pixels to still be considered the same colour.
+// JPEG-ish noise and antialiasing on gutter edges usually stays well under this.
+const DefaultTolerance = 8
+
+func near(a, b uint8, tolerance uint8) bool {
+	if a > b {
+		return a-b <= tolerance
+	}
+	return b-a <= tolerance
+}
+
+func rgbaAt(img *image.RGBA, x, y int) color.RGBA {
+	i := img.PixOffset(x, y)
+	return color.RGBA{img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3]}
+}
+
+func sameColor(a, b color.RGBA, tolerance uint8) bool {
+	return near(a.R, b.R, tolerance) && near(a.G, b.G, tolerance) && near(a.B, b.B, tolerance)
+}
+
+// Whether the span [x0, x1) of row y is a single colour, and which.
+func uniformRow(img *image.RGBA, y, x0, x1 int, tolerance uint8) (color.RGBA, bool) {
+	ref := rgbaAt(img, x0, y)
+	i := img.PixOffset(x0, y)
+	for x := x0; x < x1; x++ {
+		if !sameColor(ref, color.RGBA{img.Pix[i], img.Pix[i+1], img.Pix[i+2], 0}, tolerance) {
+			return ref, false
+		}
+		i += 4
+	}
+	return ref, true
+}
+
+// Whether the span [y0, y1) of column x is a single colour, and which.
+func uniformColumn(img *image.RGBA, x, y0, y1 int, tolerance uint8) (color.RGBA, bool) {
+	ref := rgbaAt(img, x, y0)
+	i := img.PixOffset(x, y0)
+	for y := y0; y < y1; y++ {
+		if !sameColor(ref, color.RGBA{img.Pix[i], img.Pix[i+1], img.Pix[i+2], 0}, tolerance) {
+			return ref, false
+		}
+		i += img.Stride
+	}
+	return ref, true
+}
+
+// Gutter is a run of rows (or columns) of a single colour.
+type Gutter struct {
+	Start int        `json:"start"` // First row/column of the gutter
+	End   int        `json:"end"`   // One past the last row/column of the gutter
+	Color color.RGBA `json:"-"`
+}
+
+func (g Gutter) Size() int {
+	return g.End - g.Start
+}
+
+// Middle row/column of the gutter, the least disruptive place to cut.
+func (g Gutter) Middle() int {
+	return g.Start + g.Size()/2
+}
+
+// Find the horizontal gutters in the rows [y0, y1) of the image.
+func HorizontalGutters(img *image.RGBA, y0, y1 int, tolerance uint8) []Gutter {
+	b := img.Bounds()
+	var gutters []Gutter
+	var current *Gutter
+	for y := y0; y < y1; y++ {
+		c, ok := uniformRow(img, y, b.Min.X, b.Max.X, tolerance)
+		if ok && current != nil && sameColor(current.Color, c, tolerance) {
+			current.End = y + 1
+			continue
+		}
+		if current != nil {
+			gutters = append(gutters, *current)
+			current = nil
+		}
+		if ok {
+			current = &Gutter{Start: y, End: y + 1, Color: c}
+		}
+	}
+	if current != nil {
+		gutters = append(gutters, *current)
+	}
+	return gutters
+}
+
+// Find the vertical gutters in the columns [x0, x1), looking only at the rows [y0, y1).
+func VerticalGutters(img *image.RGBA, x0, x1, y0, y1 int, tolerance uint8) []Gutter {
+	var gutters []Gutter
+	var current *Gutter
+	for x := x0; x < x1; x++ {
+		c, ok := uniformColumn(img, x, y0, y1, tolerance)
+		if ok && current != nil && sameColor(current.Color, c, tolerance) {
+			current.End = x + 1
+			continue
+		}
+		if current != nil {
+			gutters = append(gutters, *current)
+			current = nil
+		}
+		if ok {
+			current = &Gutter{Start: x, End: x + 1, Color: c}
+		}
+	}
+	if current != nil {
+		gutters = append(gutters, *current)
+	}
+	return gutters
+}
@@ -0,0 +1,147 @@
+package webtoon
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+type DetectOptions struct {
+	Tolerance uint8 // See DefaultTolerance
+	MinGutter int   // Uniform runs thinner than this don't separate panels
+	MinPanel  int   // Panels narrower or shorter than this are dropped as noise
+}
+
+var DefaultDetectOptions = DetectOptions{
+	Tolerance: DefaultTolerance,
+	MinGutter: 8,
+	MinPanel:  32,
+}
+
+// Panel is the bounding box of a single panel in a canvas.
+type Panel struct {
+	Index  int `json:"index"` // Reading order, top to bottom then left to right
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+func (p Panel) Bounds() image.Rectangle {
+	return image.Rect(p.X, p.Y, p.X+p.Width, p.Y+p.Height)
+}
+
+// Spans in [start, end) not covered by gutters at least minGutter thick, and the colours of
+// those gutters.
+func between(gutters []Gutter, start, end, minGutter int) ([][2]int, []color.RGBA) {
+	var spans [][2]int
+	var colors []color.RGBA
+	from := start
+	for _, g := range gutters {
+		if g.Size() < minGutter && g.Start != start && g.End != end {
+			continue
+		}
+		colors = append(colors, g.Color)
+		if g.Start > from {
+			spans = append(spans, [2]int{from, g.Start})
+		}
+		from = g.End
+	}
+	if from < end {
+		spans = append(spans, [2]int{from, end})
+	}
+	return spans, colors
+}
+
+// Whether c is one of the colours.
+func anyColor(colors []color.RGBA, c color.RGBA, tolerance uint8) bool {
+	for _, other := range colors {
+		if sameColor(c, other, tolerance) {
+			return true
+		}
+	}
+	return false
+}
+
+// Shrink the rectangle until no edge row or column is all one of the gutter colours, so
+// uniform lines of any other colour, like panel borders, are kept.
+func trim(img *image.RGBA, r image.Rectangle, gutterColors []color.RGBA, tolerance uint8) image.Rectangle {
+	for r.Dy() > 0 {
+		if c, ok := uniformRow(img, r.Min.Y, r.Min.X, r.Max.X, tolerance); !ok || !anyColor(gutterColors, c, tolerance) {
+			break
+		}
+		r.Min.Y++
+	}
+	for r.Dy() > 0 {
+		if c, ok := uniformRow(img, r.Max.Y-1, r.Min.X, r.Max.X, tolerance); !ok || !anyColor(gutterColors, c, tolerance) {
+			break
+		}
+		r.Max.Y--
+	}
+	for r.Dx() > 0 && r.Dy() > 0 {
+		if c, ok := uniformColumn(img, r.Min.X, r.Min.Y, r.Max.Y, tolerance); !ok || !anyColor(gutterColors, c, tolerance) {
+			break
+		}
+		r.Min.X++
+	}
+	for r.Dx() > 0 && r.Dy() > 0 {
+		if c, ok := uniformColumn(img, r.Max.X-1, r.Min.Y, r.Max.Y, tolerance); !ok || !anyColor(gutterColors, c, tolerance) {
+			break
+		}
+		r.Max.X--
+	}
+	return r
+}
+
+// Detect panels by looking for gutters: full-width uniform rows split the canvas into bands,
+// then uniform columns within each band split it into side-by-side panels.
+func DetectPanels(img *image.RGBA, opts DetectOptions) []Panel {
+	b := img.Bounds()
+	var panels []Panel
+	bands, rowColors := between(HorizontalGutters(img, b.Min.Y, b.Max.Y, opts.Tolerance), b.Min.Y, b.Max.Y, opts.MinGutter)
+	for _, band := range bands {
+		columns, columnColors := between(VerticalGutters(img, b.Min.X, b.Max.X, band[0], band[1], opts.Tolerance), b.Min.X, b.Max.X, opts.MinGutter)
+		gutterColors := append(append([]color.RGBA(nil), rowColors...), columnColors...)
+		for _, column := range columns {
+			r := trim(img, image.Rect(column[0], band[0], column[1], band[1]), gutterColors, opts.Tolerance)
+			if r.Dx() < opts.MinPanel || r.Dy() < opts.MinPanel {
+				continue
+			}
+			panels = append(panels, Panel{
+				Index:  len(panels),
+				X:      r.Min.X,
+				Y:      r.Min.Y,
+				Width:  r.Dx(),
+				Height: r.Dy(),
+			})
+		}
+	}
+	return panels
+}
+
+// Write each panel to its own numbered file in dir, named like <prefix>001.png. Returns the paths written.
+func WritePanels(dir string, prefix string, img *image.RGBA, panels []Panel, format Format, quality int) ([]string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, errors.Wrap(err, "failed creating output directory")
+	}
+
+	paths := make([]string, 0, len(panels))
+	for _, panel := range panels {
+		path := filepath.Join(dir, fmt.Sprintf("%s%03d%s", prefix, panel.Index+1, format.Extension()))
+		f, err := os.Create(path)
+		if err != nil {
+			return paths, errors.Wrap(err, "failed creating panel file")
+		}
+		err = Encode(f, img.SubImage(panel.Bounds()), format, quality)
+		f.Close()
+		if err != nil {
+			return paths, errors.Wrapf(err, "failed encoding panel %s", path)
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
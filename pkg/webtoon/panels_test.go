@@ -0,0 +1,111 @@
+package webtoon
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+var (
+	white = color.RGBA{255, 255, 255, 255}
+	black = color.RGBA{0, 0, 0, 255}
+)
+
+// White canvas with the panels drawn on it. Panels are black-framed, with a gradient inside
+// so only the frame is uniform.
+func canvas(width, height int, panels ...image.Rectangle) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), image.NewUniform(white), image.Point{}, draw.Src)
+	for _, p := range panels {
+		draw.Draw(img, p, image.NewUniform(black), image.Point{}, draw.Src)
+		for y := p.Min.Y + 2; y < p.Max.Y-2; y++ {
+			for x := p.Min.X + 2; x < p.Max.X-2; x++ {
+				img.SetRGBA(x, y, color.RGBA{uint8(x * 7), uint8(y * 3), 128, 255})
+			}
+		}
+	}
+	return img
+}
+
+func TestDetectPanels(t *testing.T) {
+	tests := []struct {
+		name   string
+		img    *image.RGBA
+		panels []image.Rectangle
+	}{
+		{
+			"stacked",
+			canvas(200, 400, image.Rect(10, 10, 190, 150), image.Rect(10, 200, 190, 390)),
+			[]image.Rectangle{image.Rect(10, 10, 190, 150), image.Rect(10, 200, 190, 390)},
+		},
+		{
+			"side by side",
+			canvas(200, 200, image.Rect(10, 10, 90, 190), image.Rect(110, 10, 190, 190)),
+			[]image.Rectangle{image.Rect(10, 10, 90, 190), image.Rect(110, 10, 190, 190)},
+		},
+		{
+			"noise dropped",
+			canvas(200, 300, image.Rect(10, 10, 190, 150), image.Rect(90, 250, 100, 260)),
+			[]image.Rectangle{image.Rect(10, 10, 190, 150)},
+		},
+		{
+			"thin gutter doesn't split",
+			canvas(200, 300, image.Rect(10, 10, 190, 150), image.Rect(10, 152, 190, 290)),
+			[]image.Rectangle{image.Rect(10, 10, 190, 290)},
+		},
+		{
+			"full bleed", // Uniform lines on the edges of the canvas are margins, whatever their colour
+			canvas(100, 100, image.Rect(0, 0, 100, 100)),
+			[]image.Rectangle{image.Rect(2, 2, 98, 98)},
+		},
+		{"blank", canvas(100, 100), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			panels := DetectPanels(tt.img, DefaultDetectOptions)
+			if len(panels) != len(tt.panels) {
+				t.Fatalf("got %d panels %+v, want %d", len(panels), panels, len(tt.panels))
+			}
+			for i, p := range panels {
+				if p.Index != i || p.Bounds() != tt.panels[i] {
+					t.Errorf("panel %d: got %+v, want %v", i, p, tt.panels[i])
+				}
+			}
+		})
+	}
+}
+
+func TestTrimKeepsOtherColors(t *testing.T) {
+	img := canvas(100, 100, image.Rect(20, 20, 80, 80))
+	gutters := []color.RGBA{white}
+	if got, want := trim(img, img.Bounds(), gutters, DefaultTolerance), image.Rect(20, 20, 80, 80); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// Black isn't a gutter colour, so the frame stays even if it's the edge
+	if got, want := trim(img, image.Rect(20, 20, 80, 80), gutters, DefaultTolerance), image.Rect(20, 20, 80, 80); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := trim(img, image.Rect(20, 20, 80, 80), []color.RGBA{white, black}, DefaultTolerance), image.Rect(22, 22, 78, 78); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestHorizontalGutters(t *testing.T) {
+	img := canvas(100, 100, image.Rect(0, 10, 100, 50), image.Rect(10, 60, 90, 100))
+	want := []Gutter{
+		{Start: 0, End: 10, Color: white},
+		{Start: 10, End: 12, Color: black},
+		{Start: 48, End: 50, Color: black},
+		{Start: 50, End: 60, Color: white},
+	}
+	got := HorizontalGutters(img, 0, 100, DefaultTolerance)
+	if len(got) != len(want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Errorf("gutter %d: got %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package webtoon
+
+import (
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"golang.org/x/image/draw"
+)
+
+type Format string
+
+const (
+	FormatJPEG Format = "jpeg"
+	FormatPNG  Format = "png"
+)
+
+func (f Format) Extension() string {
+	if f == FormatPNG {
+		return ".png"
+	}
+	return ".jpg"
+}
+
+// Preset holds the limits a platform puts on uploaded episode strips.
+type Preset struct {
+	Width     int    // Width strips are resized to. 0 keeps the canvas width
+	MaxHeight int    // Maximum height of a single strip
+	Format    Format // Format strips are written in
+	Quality   int    // JPEG quality, ignored for PNG
+}
+
+// Limits of common platforms at the time of writing. Check them against the platform's current upload guidelines.
+var Presets = map[string]Preset{
+	"webtoon":  {Width: 800, MaxHeight: 1280, Format: FormatJPEG, Quality: 90}, // WEBTOON (CANVAS)
+	"tapas":    {Width: 940, MaxHeight: 4000, Format: FormatJPEG, Quality: 90},
+	"original": {Width: 0, MaxHeight: 2000, Format: FormatPNG},
+}
+
+type SliceOptions struct {
+	MaxHeight int   // Maximum height of a strip
+	Tolerance uint8 // See DefaultTolerance
+	// Fraction of MaxHeight a strip must at least reach before a gutter is accepted as a cut line.
+	// Keeps the slicer from producing tiny strips when a gutter sits right after the previous cut.
+	MinFill float64
+}
+
+// Resize the image to the given width, keeping its aspect ratio.
+func Resize(img image.Image, width int) *image.RGBA {
+	b := img.Bounds()
+	if width <= 0 || width == b.Dx() {
+		if rgba, ok := img.(*image.RGBA); ok && b.Min == (image.Point{}) {
+			return rgba
+		}
+		width = b.Dx()
+	}
+	height := (b.Dy()*width + b.Dx()/2) / b.Dx()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	if width == b.Dx() {
+		draw.Copy(dst, image.Point{}, img, b, draw.Src, nil)
+	} else {
+		draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	}
+	return dst
+}
+
+// Find where to cut the image into strips no taller than MaxHeight.
+// Cuts are placed in the middle of the last gutter that fits in a strip, or at MaxHeight when there is none.
+// The returned rows are the bottom (exclusive) of each strip, the last one being the image's bottom.
+func Cuts(img *image.RGBA, opts SliceOptions) []int {
+	b := img.Bounds()
+	if opts.MaxHeight <= 0 {
+		return []int{b.Max.Y}
+	}
+	if opts.MinFill <= 0 || opts.MinFill > 1 {
+		opts.MinFill = 0.5
+	}
+
+	var cuts []int
+	top := b.Min.Y
+	for b.Max.Y-top > opts.MaxHeight {
+		limit := top + opts.MaxHeight
+		cut := limit
+		gutters := HorizontalGutters(img, top+int(float64(opts.MaxHeight)*opts.MinFill), limit+1, opts.Tolerance)
+		if len(gutters) > 0 {
+			g := gutters[len(gutters)-1]
+			cut = g.Middle()
+			if g.End > limit {
+				// The gutter continues past the limit, cut as deep into it as allowed
+				cut = limit
+			}
+		}
+		if cut <= top {
+			cut = limit
+		}
+		cuts = append(cuts, cut)
+		top = cut
+	}
+	return append(cuts, b.Max.Y)
+}
+
+// Slice the image into strips no taller than opts.MaxHeight.
+func Slice(img *image.RGBA, opts SliceOptions) []*image.RGBA {
+	b := img.Bounds()
+	cuts := Cuts(img, opts)
+	strips := make([]*image.RGBA, len(cuts))
+	top := b.Min.Y
+	for i, cut := range cuts {
+		strips[i] = img.SubImage(image.Rect(b.Min.X, top, b.Max.X, cut)).(*image.RGBA)
+		top = cut
+	}
+	return strips
+}
+
+// Resize and slice the image according to the preset.
+func SlicePreset(img image.Image, preset Preset) []*image.RGBA {
+	return Slice(Resize(img, preset.Width), SliceOptions{
+		MaxHeight: preset.MaxHeight,
+		Tolerance: DefaultTolerance,
+	})
+}
+
+// Encode one strip in the given format.
+func Encode(w io.Writer, img image.Image, format Format, quality int) error {
+	if format == FormatPNG {
+		return png.Encode(w, img)
+	}
+	if quality <= 0 {
+		quality = jpeg.DefaultQuality
+	}
+	return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+}
+
+// Write strips to numbered files in dir, named like <prefix>001.jpg. Returns the paths written.
+func WriteStrips(dir string, prefix string, strips []*image.RGBA, format Format, quality int) ([]string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, errors.Wrap(err, "failed creating output directory")
+	}
+
+	paths := make([]string, 0, len(strips))
+	for i, strip := range strips {
+		path := filepath.Join(dir, fmt.Sprintf("%s%03d%s", prefix, i+1, format.Extension()))
+		f, err := os.Create(path)
+		if err != nil {
+			return paths, errors.Wrap(err, "failed creating strip file")
+		}
+		err = Encode(f, strip, format, quality)
+		f.Close()
+		if err != nil {
+			return paths, errors.Wrapf(err, "failed encoding strip %s", path)
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
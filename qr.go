@@ -0,0 +1,24 @@
+package clipremote
+
+import (
+	"image"
+
+	"github.com/makiuchi-d/gozxing"
+	"github.com/makiuchi-d/gozxing/qrcode"
+	"github.com/pkg/errors"
+)
+
+// Read the connection URL from an image of the QR code CSP shows, like a screenshot.
+func DecodeQR(img image.Image) (string, error) {
+	bmp, err := gozxing.NewBinaryBitmapFromImage(img)
+	if err != nil {
+		return "", errors.Wrap(err, "failed preparing QR code image")
+	}
+	result, err := qrcode.NewQRCodeReader().Decode(bmp, map[gozxing.DecodeHintType]interface{}{
+		gozxing.DecodeHintType_TRY_HARDER: true, // Screenshots have a lot more than the code in them
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "no QR code found in image")
+	}
+	return result.GetText(), nil
+}
@@ -0,0 +1,63 @@
+package clipremote
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// Session holds what's needed to connect to a CSP instance again later.
+// The password changes every time the client authenticates, so save the session after that,
+// see Client.Session.
+type Session struct {
+	IPAddresses []string `json:"ip_addresses"`
+	Port        uint16   `json:"port"`
+	Password    string   `json:"password"`
+	Generation  string   `json:"generation"`
+}
+
+// Create a session from the URL in the QR code, see DecodeConfig.
+func SessionFromURL(connectionURL string) (*Session, error) {
+	ipAddresses, port, password, generation, err := DecodeConfig(connectionURL)
+	if err != nil {
+		return nil, err
+	}
+	return &Session{
+		IPAddresses: ipAddresses,
+		Port:        port,
+		Password:    password,
+		Generation:  generation,
+	}, nil
+}
+
+func LoadSession(path string) (*Session, error) {
+	bin, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed reading session file")
+	}
+	var session Session
+	if err = json.Unmarshal(bin, &session); err != nil {
+		return nil, errors.Wrap(err, "failed parsing session file")
+	}
+	return &session, nil
+}
+
+// Save the session. The file is only readable by the current user, since it contains the password.
+func (s *Session) Save(path string) error {
+	bin, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed encoding session")
+	}
+	return errors.Wrap(os.WriteFile(path, bin, 0o600), "failed writing session file")
+}
+
+// Current session of the client, with the password it authenticated with last.
+func (c *Client) Session() *Session {
+	return &Session{
+		IPAddresses: c.ipAddresses,
+		Port:        c.port,
+		Password:    c.password,
+		Generation:  c.generation,
+	}
+}
@@ -0,0 +1,68 @@
+package clipremote
+
+import (
+	"context"
+	"io"
+	"net"
+)
+
+// Transport carries packets between the client and CSP. Any net.Conn is one, so the client
+// can also run over net.Pipe, a Unix socket, or a wrapped connection. In-memory fakes only
+// need to read and write.
+type Transport interface {
+	io.Reader
+	io.Writer
+	io.Closer
+}
+
+// TransportFactory opens a transport to CSP. The client calls it again to reconnect when
+// CSP closes the transport.
+type TransportFactory func(ctx context.Context) (Transport, error)
+
+// Factory dialing the address with the options' dial function and wrapper.
+func (o *clientOptions) transportTo(address string) TransportFactory {
+	return func(ctx context.Context) (Transport, error) {
+		return o.open(ctx, address)
+	}
+}
+
+// Where the transport goes, if it says. Empty otherwise.
+func transportAddr(t Transport) string {
+	if conn, ok := t.(interface{ RemoteAddr() net.Addr }); ok && conn.RemoteAddr() != nil {
+		return conn.RemoteAddr().String()
+	}
+	return ""
+}
+
+// Wrap transports from open that are connections with the options' wrapper, if there's one.
+func (o *clientOptions) wrapTransports(open TransportFactory) TransportFactory {
+	if o.wrapConn == nil {
+		return open
+	}
+	return func(ctx context.Context) (Transport, error) {
+		t, err := open(ctx)
+		if conn, ok := t.(net.Conn); ok && err == nil {
+			return o.wrapConn(conn), nil
+		}
+		return t, err
+	}
+}
+
+// Connect to CSP over transports from open instead of dialing the addresses from the share
+// URL. The client's session has no addresses then. WithConnWrapper applies to transports
+// that are a net.Conn and WithDialTimeout limits reopening them, while WithDialer,
+// WithDialFunc and WithDialStagger don't apply.
+func NewWithTransport(ctx context.Context, open TransportFactory, generation string, opts ...ClientOption) (*Client, error) {
+	o := defaultClientOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+	open = o.wrapTransports(open)
+	conn, err := open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	client := newClient(conn, open, nil, 0, generation, o)
+	client.log.Infoln("connected to " + client.RemoteAddr())
+	return client, nil
+}